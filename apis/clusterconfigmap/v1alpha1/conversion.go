@@ -0,0 +1,98 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+var _ conversion.Convertible = &ClusterConfigMap{}
+
+// ConvertTo converts the ClusterConfigMap to the v1beta1 hub version, moving the flat fields into the spec.
+func (in *ClusterConfigMap) ConvertTo(hub conversion.Hub) error {
+	dst, ok := hub.(*v1beta1.ClusterConfigMap)
+	if !ok {
+		return fmt.Errorf("unsupported conversion to %T", hub)
+	}
+	dst.ObjectMeta = in.ObjectMeta
+	dst.Spec = v1beta1.ClusterConfigMapSpec{
+		Data:       in.Data,
+		BinaryData: in.BinaryData,
+		Immutable:  in.Immutable,
+	}
+	// empty allowed namespaces allow every namespace, the same as an unset access policy
+	if len(in.AllowedNamespaces) > 0 || in.NamespaceSelector != nil {
+		dst.Spec.Access = &v1beta1.AccessPolicy{
+			AllowedNamespaces: in.AllowedNamespaces,
+			NamespaceSelector: in.NamespaceSelector,
+		}
+	}
+	if in.FileAttributes != nil {
+		dst.Spec.Projection = &v1beta1.Projection{
+			FileAttributes: make(map[string]v1beta1.FileAttributes, len(in.FileAttributes)),
+		}
+		for key, attrs := range in.FileAttributes {
+			dst.Spec.Projection.FileAttributes[key] = v1beta1.FileAttributes(attrs)
+		}
+	}
+	if in.Mirror != nil {
+		dst.Spec.Mirror = &v1beta1.Mirror{NamespaceSelector: in.Mirror.NamespaceSelector}
+	}
+
+	dst.Status = v1beta1.ClusterConfigMapStatus{
+		ObservedGeneration: in.Status.ObservedGeneration,
+		Hash:               in.Status.Hash,
+	}
+	if in.Status.Nodes != nil {
+		dst.Status.Nodes = make([]v1beta1.NodeStatus, len(in.Status.Nodes))
+		for i, node := range in.Status.Nodes {
+			dst.Status.Nodes[i] = v1beta1.NodeStatus(node)
+		}
+	}
+	return nil
+}
+
+// ConvertFrom converts the v1beta1 hub version to the ClusterConfigMap, flattening the spec. Empty projections are
+// dropped, they are equivalent to unset ones. Access policies which allow no namespace are rejected by the v1beta1
+// schema, as they would allow every namespace once converted.
+func (in *ClusterConfigMap) ConvertFrom(hub conversion.Hub) error {
+	src, ok := hub.(*v1beta1.ClusterConfigMap)
+	if !ok {
+		return fmt.Errorf("unsupported conversion from %T", hub)
+	}
+	in.ObjectMeta = src.ObjectMeta
+	in.Data = src.Spec.Data
+	in.BinaryData = src.Spec.BinaryData
+	in.Immutable = src.Spec.Immutable
+	in.AllowedNamespaces = nil
+	in.NamespaceSelector = nil
+	if src.Spec.Access != nil {
+		in.AllowedNamespaces = src.Spec.Access.AllowedNamespaces
+		in.NamespaceSelector = src.Spec.Access.NamespaceSelector
+	}
+	in.FileAttributes = nil
+	if src.Spec.Projection != nil && src.Spec.Projection.FileAttributes != nil {
+		in.FileAttributes = make(map[string]FileAttributes, len(src.Spec.Projection.FileAttributes))
+		for key, attrs := range src.Spec.Projection.FileAttributes {
+			in.FileAttributes[key] = FileAttributes(attrs)
+		}
+	}
+	in.Mirror = nil
+	if src.Spec.Mirror != nil {
+		in.Mirror = &Mirror{NamespaceSelector: src.Spec.Mirror.NamespaceSelector}
+	}
+
+	in.Status = ClusterConfigMapStatus{
+		ObservedGeneration: src.Status.ObservedGeneration,
+		Hash:               src.Status.Hash,
+	}
+	if src.Status.Nodes != nil {
+		in.Status.Nodes = make([]NodeStatus, len(src.Status.Nodes))
+		for i, node := range src.Status.Nodes {
+			in.Status.Nodes[i] = NodeStatus(node)
+		}
+	}
+	return nil
+}
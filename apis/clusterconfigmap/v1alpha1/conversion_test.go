@@ -0,0 +1,174 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_ClusterConfigMap_Conversion_RoundTrip(t *testing.T) {
+	immutable := true
+	uid := int64(1000)
+	gid := int64(2000)
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+
+	type testcase struct {
+		description string
+		ccm         *ClusterConfigMap
+	}
+	tests := []testcase{
+		{
+			description: "empty cluster config maps should round trip",
+			ccm:         &ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"}},
+		},
+		{
+			description: "every field should round trip",
+			ccm: &ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "test-ccm",
+					ResourceVersion: "7",
+					Generation:      3,
+					Labels:          map[string]string{"app": "test"},
+				},
+				Data:       map[string]string{"config.yaml": "key: value"},
+				BinaryData: map[string][]byte{"config.bin": {0xff, 0x00}},
+				FileAttributes: map[string]FileAttributes{
+					"config.yaml": {Mode: "0600", UID: &uid, GID: &gid},
+				},
+				Immutable:         &immutable,
+				AllowedNamespaces: []string{"team-a", "team-b"},
+				NamespaceSelector: selector,
+				Mirror:            &Mirror{NamespaceSelector: *selector},
+				Status: ClusterConfigMapStatus{
+					ObservedGeneration: 3,
+					Hash:               "hash",
+					Nodes: []NodeStatus{
+						{Name: "node-a", Volumes: 2, SyncedHash: "hash", LastUpdateTime: metav1.Unix(1700000000, 0)},
+					},
+				},
+			},
+		},
+		{
+			description: "only a namespace selector should round trip",
+			ccm: &ClusterConfigMap{
+				ObjectMeta:        metav1.ObjectMeta{Name: "test-ccm"},
+				NamespaceSelector: &metav1.LabelSelector{},
+			},
+		},
+		{
+			description: "empty file attributes should round trip",
+			ccm: &ClusterConfigMap{
+				ObjectMeta:     metav1.ObjectMeta{Name: "test-ccm"},
+				FileAttributes: map[string]FileAttributes{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		hub := &v1beta1.ClusterConfigMap{}
+		require.NoError(t, test.ccm.DeepCopy().ConvertTo(hub), test.description)
+		got := &ClusterConfigMap{}
+		require.NoError(t, got.ConvertFrom(hub), test.description)
+		require.Equal(t, test.ccm, got, test.description)
+	}
+}
+
+func Test_ClusterConfigMap_Conversion_FromHub(t *testing.T) {
+	immutable := true
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+
+	type testcase struct {
+		description string
+		hub         *v1beta1.ClusterConfigMap
+		expected    *v1beta1.ClusterConfigMap
+	}
+	tests := []testcase{
+		{
+			description: "every field should round trip",
+			hub: &v1beta1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+				Spec: v1beta1.ClusterConfigMapSpec{
+					Data:       map[string]string{"config.yaml": "key: value"},
+					BinaryData: map[string][]byte{"config.bin": {0xff}},
+					Immutable:  &immutable,
+					Access: &v1beta1.AccessPolicy{
+						AllowedNamespaces: []string{"team-a"},
+						NamespaceSelector: selector,
+					},
+					Projection: &v1beta1.Projection{
+						FileAttributes: map[string]v1beta1.FileAttributes{"config.yaml": {Mode: "0644"}},
+					},
+					Mirror: &v1beta1.Mirror{NamespaceSelector: *selector},
+				},
+				Status: v1beta1.ClusterConfigMapStatus{
+					Hash:  "hash",
+					Nodes: []v1beta1.NodeStatus{{Name: "node-a", Volumes: 1}},
+				},
+			},
+		},
+		{
+			description: "empty projections should be dropped",
+			hub: &v1beta1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+				Spec: v1beta1.ClusterConfigMapSpec{
+					Projection: &v1beta1.Projection{},
+				},
+			},
+			expected: &v1beta1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		ccm := &ClusterConfigMap{}
+		require.NoError(t, ccm.ConvertFrom(test.hub.DeepCopy()), test.description)
+		got := &v1beta1.ClusterConfigMap{}
+		require.NoError(t, ccm.ConvertTo(got), test.description)
+		expected := test.expected
+		if expected == nil {
+			expected = test.hub
+		}
+		require.Equal(t, expected, got, test.description)
+	}
+}
+
+func Test_ClusterConfigMap_Conversion_Access(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+
+	type testcase struct {
+		description string
+		ccm         *ClusterConfigMap
+		expected    *v1beta1.AccessPolicy
+	}
+	tests := []testcase{
+		{
+			description: "cluster config maps without restrictions should not have an access policy",
+			ccm:         &ClusterConfigMap{},
+		},
+		{
+			description: "empty allowed namespaces allow every namespace, and should not have an access policy",
+			ccm:         &ClusterConfigMap{AllowedNamespaces: []string{}},
+		},
+		{
+			description: "allowed namespaces should restrict the access policy",
+			ccm:         &ClusterConfigMap{AllowedNamespaces: []string{"team-a"}},
+			expected:    &v1beta1.AccessPolicy{AllowedNamespaces: []string{"team-a"}},
+		},
+		{
+			description: "empty allowed namespaces with a selector should only be restricted by the selector",
+			ccm:         &ClusterConfigMap{AllowedNamespaces: []string{}, NamespaceSelector: selector},
+			expected:    &v1beta1.AccessPolicy{AllowedNamespaces: []string{}, NamespaceSelector: selector},
+		},
+	}
+
+	for _, test := range tests {
+		hub := &v1beta1.ClusterConfigMap{}
+		require.NoError(t, test.ccm.ConvertTo(hub), test.description)
+		require.Equal(t, test.expected, hub.Spec.Access, test.description)
+	}
+}
@@ -7,7 +7,13 @@ import (
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:scope=Cluster,shortName=ccm
-// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.hash`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+// +kubebuilder:validation:XValidation:rule="!has(self.data) || !has(self.binaryData) || self.data.all(k, !(k in self.binaryData))",message="keys in data and binaryData must not overlap"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.immutable) && self.immutable)",message="immutable can not be unset once it is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.data) == has(oldSelf.data) && (!has(self.data) || self.data == oldSelf.data))",message="data can not be changed when immutable is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.binaryData) == has(oldSelf.binaryData) && (!has(self.binaryData) || self.binaryData == oldSelf.binaryData))",message="binaryData can not be changed when immutable is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.fileAttributes) == has(oldSelf.fileAttributes) && (!has(self.fileAttributes) || self.fileAttributes == oldSelf.fileAttributes))",message="fileAttributes can not be changed when immutable is set"
 type ClusterConfigMap struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
@@ -18,7 +24,110 @@ type ClusterConfigMap struct {
 	// The keys stored in Data must not overlap with the keys in
 	// the BinaryData field, this is enforced during validation process.
 	// +optional
+	// +kubebuilder:validation:XValidation:rule="self.all(k, size(k) <= 253 && k != '.' && !k.startsWith('..') && k.matches('^[-._a-zA-Z0-9]+$'))",message="keys must consist of alphanumeric characters, '-', '_' or '.', and must not start with '..'"
 	Data map[string]string `json:"data,omitempty"`
+
+	// BinaryData contains the binary data.
+	// Each key must consist of alphanumeric characters, '-', '_' or '.'.
+	// BinaryData can contain byte sequences that are not in the UTF-8 range.
+	// The keys stored in BinaryData must not overlap with the ones in
+	// the Data field, this is enforced during validation process.
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="self.all(k, size(k) <= 253 && k != '.' && !k.startsWith('..') && k.matches('^[-._a-zA-Z0-9]+$'))",message="keys must consist of alphanumeric characters, '-', '_' or '.', and must not start with '..'"
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+
+	// FileAttributes sets the permissions and ownership of the files individual keys are published to.
+	// Each key must be present in Data or BinaryData. Keys without file attributes use the mode set on the volume.
+	// +optional
+	FileAttributes map[string]FileAttributes `json:"fileAttributes,omitempty"`
+
+	// Immutable, if set to true, ensures that Data, BinaryData and FileAttributes can not be updated, only the
+	// object metadata can be modified. Once set, it can not be unset. Nodes do not refresh the volumes of immutable
+	// ClusterConfigMaps, and they are mirrored as immutable ConfigMaps.
+	// +optional
+	Immutable *bool `json:"immutable,omitempty"`
+
+	// AllowedNamespaces lists the namespaces of pods allowed to mount the ClusterConfigMap.
+	// Pods in any namespace may mount the ClusterConfigMap when neither AllowedNamespaces
+	// nor NamespaceSelector are set.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// NamespaceSelector selects the namespaces of pods allowed to mount the ClusterConfigMap,
+	// in addition to the AllowedNamespaces. An empty selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Mirror replicates the ClusterConfigMap into native ConfigMaps, which pods can use as environment variable
+	// sources. ClusterConfigMaps without a Mirror are not replicated.
+	// +optional
+	Mirror *Mirror `json:"mirror,omitempty"`
+
+	// Status reports the nodes consuming the ClusterConfigMap and the contents their volumes are synced to.
+	// +optional
+	Status ClusterConfigMapStatus `json:"status,omitempty"`
+}
+
+// Mirror selects the namespaces a ClusterConfigMap is replicated into.
+type Mirror struct {
+	// NamespaceSelector selects the namespaces the ClusterConfigMap is mirrored into, as a ConfigMap of the same name.
+	// Only namespaces allowed to mount the ClusterConfigMap are selected. An empty selector selects every allowed
+	// namespace.
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+}
+
+// FileAttributes are the permissions and ownership of the file a key is published to.
+type FileAttributes struct {
+	// Mode is the octal unix permissions of the file, such as "0755".
+	// It takes precedence over the mode set on the volume.
+	// +kubebuilder:validation:Pattern=`^[0-7]{3,4}$`
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// UID is the user id owning the file.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	UID *int64 `json:"uid,omitempty"`
+
+	// GID is the group id owning the file.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	GID *int64 `json:"gid,omitempty"`
+}
+
+// ClusterConfigMapStatus is the observed state of a ClusterConfigMap, as reported by the csi node plugins.
+type ClusterConfigMapStatus struct {
+	// ObservedGeneration is the most recent generation observed by a node plugin.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Hash is the content hash of the data and binaryData of the observed generation.
+	// +optional
+	Hash string `json:"hash,omitempty"`
+
+	// Nodes lists the nodes with volumes published from the ClusterConfigMap.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Nodes []NodeStatus `json:"nodes,omitempty"`
+}
+
+// NodeStatus reports the volumes published from a ClusterConfigMap on a single node.
+type NodeStatus struct {
+	// Name is the name of the node.
+	Name string `json:"name"`
+
+	// Volumes is the number of volumes currently published on the node.
+	Volumes int32 `json:"volumes"`
+
+	// SyncedHash is the content hash the volumes on the node were last populated from.
+	// When the volumes on the node disagree, the hash of the least recently synced volume is reported.
+	// +optional
+	SyncedHash string `json:"syncedHash,omitempty"`
+
+	// LastUpdateTime is the last time the node updated its status.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
 }
 
 // +kubebuilder:object:root=true
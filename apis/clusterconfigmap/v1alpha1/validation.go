@@ -0,0 +1,71 @@
+package v1alpha1
+
+import (
+	"sort"
+	"strconv"
+
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Validate checks that every key of Data and BinaryData is a valid config map key, which can be safely
+// materialized as a file in a volume, that no key is present in both Data and BinaryData, and that the
+// FileAttributes refer to existing keys. It also validates the namespace access policy and mirror.
+func (in *ClusterConfigMap) Validate() field.ErrorList {
+	var errs field.ErrorList
+
+	dataPath := field.NewPath("data")
+	for _, key := range sortedKeys(in.Data) {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			errs = append(errs, field.Invalid(dataPath.Key(key), key, msg))
+		}
+	}
+
+	binaryDataPath := field.NewPath("binaryData")
+	for _, key := range sortedKeys(in.BinaryData) {
+		for _, msg := range validation.IsConfigMapKey(key) {
+			errs = append(errs, field.Invalid(binaryDataPath.Key(key), key, msg))
+		}
+		if _, ok := in.Data[key]; ok {
+			errs = append(errs, field.Duplicate(binaryDataPath.Key(key), key))
+		}
+	}
+
+	fileAttributesPath := field.NewPath("fileAttributes")
+	for _, key := range sortedKeys(in.FileAttributes) {
+		_, inData := in.Data[key]
+		_, inBinaryData := in.BinaryData[key]
+		if !inData && !inBinaryData {
+			errs = append(errs, field.NotFound(fileAttributesPath.Key(key), key))
+		}
+		if mode := in.FileAttributes[key].Mode; mode != "" {
+			if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+				errs = append(errs, field.Invalid(fileAttributesPath.Key(key).Child("mode"), mode, "must be octal unix permissions"))
+			}
+		}
+	}
+
+	allowedNamespacesPath := field.NewPath("allowedNamespaces")
+	for i, namespace := range in.AllowedNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(allowedNamespacesPath.Index(i), namespace, msg))
+		}
+	}
+	if in.NamespaceSelector != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(in.NamespaceSelector, metav1validation.LabelSelectorValidationOptions{}, field.NewPath("namespaceSelector"))...)
+	}
+	if in.Mirror != nil {
+		errs = append(errs, metav1validation.ValidateLabelSelector(&in.Mirror.NamespaceSelector, metav1validation.LabelSelectorValidationOptions{}, field.NewPath("mirror", "namespaceSelector"))...)
+	}
+	return errs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,5 @@
+// Package v1beta1 contains resources for cluster-config-maps
+// +kubebuilder:object:generate=true
+// +groupName=indeed.com
+// +versionName=v1beta1
+package v1beta1
@@ -0,0 +1,167 @@
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:scope=Cluster,shortName=ccm
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Hash",type=string,JSONPath=`.status.hash`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+type ClusterConfigMap struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the configuration data of the ClusterConfigMap and how it is consumed.
+	// +optional
+	Spec ClusterConfigMapSpec `json:"spec,omitempty"`
+
+	// Status reports the nodes consuming the ClusterConfigMap and the contents their volumes are synced to.
+	// +optional
+	Status ClusterConfigMapStatus `json:"status,omitempty"`
+}
+
+// ClusterConfigMapSpec is the configuration data of a ClusterConfigMap, the namespaces allowed to consume it, and how
+// it is projected into volumes and mirrored into ConfigMaps.
+// +kubebuilder:validation:XValidation:rule="!has(self.data) || !has(self.binaryData) || self.data.all(k, !(k in self.binaryData))",message="keys in data and binaryData must not overlap"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.immutable) && self.immutable)",message="immutable can not be unset once it is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.data) == has(oldSelf.data) && (!has(self.data) || self.data == oldSelf.data))",message="data can not be changed when immutable is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.binaryData) == has(oldSelf.binaryData) && (!has(self.binaryData) || self.binaryData == oldSelf.binaryData))",message="binaryData can not be changed when immutable is set"
+// +kubebuilder:validation:XValidation:rule="!has(oldSelf.immutable) || !oldSelf.immutable || (has(self.projection) == has(oldSelf.projection) && (!has(self.projection) || self.projection == oldSelf.projection))",message="projection can not be changed when immutable is set"
+type ClusterConfigMapSpec struct {
+	// Data contains the configuration data.
+	// Each key must consist of alphanumeric characters, '-', '_' or '.'.
+	// Values with non-UTF-8 byte sequences must use the BinaryData field.
+	// The keys stored in Data must not overlap with the keys in
+	// the BinaryData field, this is enforced during validation process.
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="self.all(k, size(k) <= 253 && k != '.' && !k.startsWith('..') && k.matches('^[-._a-zA-Z0-9]+$'))",message="keys must consist of alphanumeric characters, '-', '_' or '.', and must not start with '..'"
+	Data map[string]string `json:"data,omitempty"`
+
+	// BinaryData contains the binary data.
+	// Each key must consist of alphanumeric characters, '-', '_' or '.'.
+	// BinaryData can contain byte sequences that are not in the UTF-8 range.
+	// The keys stored in BinaryData must not overlap with the ones in
+	// the Data field, this is enforced during validation process.
+	// +optional
+	// +kubebuilder:validation:XValidation:rule="self.all(k, size(k) <= 253 && k != '.' && !k.startsWith('..') && k.matches('^[-._a-zA-Z0-9]+$'))",message="keys must consist of alphanumeric characters, '-', '_' or '.', and must not start with '..'"
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+
+	// Immutable, if set to true, ensures that Data, BinaryData and Projection can not be updated, only the
+	// object metadata can be modified. Once set, it can not be unset. Nodes do not refresh the volumes of immutable
+	// ClusterConfigMaps, and they are mirrored as immutable ConfigMaps.
+	// +optional
+	Immutable *bool `json:"immutable,omitempty"`
+
+	// Access restricts the namespaces of pods allowed to mount the ClusterConfigMap.
+	// Pods in any namespace may mount the ClusterConfigMap when it is not set. An access policy which allows no
+	// namespace is rejected, omit it to allow every namespace.
+	// +optional
+	Access *AccessPolicy `json:"access,omitempty"`
+
+	// Projection sets how the keys of the ClusterConfigMap are projected into the files of a volume.
+	// +optional
+	Projection *Projection `json:"projection,omitempty"`
+
+	// Mirror replicates the ClusterConfigMap into native ConfigMaps, which pods can use as environment variable
+	// sources. ClusterConfigMaps without a Mirror are not replicated.
+	// +optional
+	Mirror *Mirror `json:"mirror,omitempty"`
+}
+
+// AccessPolicy selects the namespaces of pods allowed to mount a ClusterConfigMap. A namespace is allowed if it is
+// listed in AllowedNamespaces or matches the NamespaceSelector. At least one namespace must be listed, or a selector
+// set.
+// +kubebuilder:validation:XValidation:rule="(has(self.allowedNamespaces) && size(self.allowedNamespaces) > 0) || has(self.namespaceSelector)",message="access must list allowedNamespaces or set a namespaceSelector, omit access to allow every namespace"
+type AccessPolicy struct {
+	// AllowedNamespaces lists the namespaces of pods allowed to mount the ClusterConfigMap.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// NamespaceSelector selects the namespaces of pods allowed to mount the ClusterConfigMap,
+	// in addition to the AllowedNamespaces. An empty selector selects every namespace.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// Projection sets the defaults of the files the keys of a ClusterConfigMap are projected to, which take precedence
+// over the attributes set on the volume.
+type Projection struct {
+	// FileAttributes sets the permissions and ownership of the files individual keys are published to.
+	// Each key must be present in Data or BinaryData. Keys without file attributes use the mode set on the volume.
+	// +optional
+	FileAttributes map[string]FileAttributes `json:"fileAttributes,omitempty"`
+}
+
+// Mirror selects the namespaces a ClusterConfigMap is replicated into.
+type Mirror struct {
+	// NamespaceSelector selects the namespaces the ClusterConfigMap is mirrored into, as a ConfigMap of the same name.
+	// Only namespaces allowed to mount the ClusterConfigMap are selected. An empty selector selects every allowed
+	// namespace.
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+}
+
+// FileAttributes are the permissions and ownership of the file a key is published to.
+type FileAttributes struct {
+	// Mode is the octal unix permissions of the file, such as "0755".
+	// It takes precedence over the mode set on the volume.
+	// +kubebuilder:validation:Pattern=`^[0-7]{3,4}$`
+	// +optional
+	Mode string `json:"mode,omitempty"`
+
+	// UID is the user id owning the file.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	UID *int64 `json:"uid,omitempty"`
+
+	// GID is the group id owning the file.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	GID *int64 `json:"gid,omitempty"`
+}
+
+// ClusterConfigMapStatus is the observed state of a ClusterConfigMap, as reported by the csi node plugins.
+type ClusterConfigMapStatus struct {
+	// ObservedGeneration is the most recent generation observed by a node plugin.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Hash is the content hash of the data and binaryData of the observed generation.
+	// +optional
+	Hash string `json:"hash,omitempty"`
+
+	// Nodes lists the nodes with volumes published from the ClusterConfigMap.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Nodes []NodeStatus `json:"nodes,omitempty"`
+}
+
+// NodeStatus reports the volumes published from a ClusterConfigMap on a single node.
+type NodeStatus struct {
+	// Name is the name of the node.
+	Name string `json:"name"`
+
+	// Volumes is the number of volumes currently published on the node.
+	Volumes int32 `json:"volumes"`
+
+	// SyncedHash is the content hash the volumes on the node were last populated from.
+	// When the volumes on the node disagree, the hash of the least recently synced volume is reported.
+	// +optional
+	SyncedHash string `json:"syncedHash,omitempty"`
+
+	// LastUpdateTime is the last time the node updated its status.
+	// +optional
+	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+type ClusterConfigMapList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	// Items is the list of ClusterConfigMap.
+	Items []ClusterConfigMap `json:"items"`
+}
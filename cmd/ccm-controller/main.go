@@ -0,0 +1,135 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	ccmv1alpha1 "indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+	ccmv1beta1 "indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+	"indeed.com/compute-platform/cluster-config-map/pkg/mirror"
+	ccmwebhook "indeed.com/compute-platform/cluster-config-map/pkg/webhook"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	// to ensure that exec-entrypoint and run can make use of them.
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	//+kubebuilder:scaffold:imports
+)
+
+var (
+	scheme = runtime.NewScheme()
+)
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ccmv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(ccmv1beta1.AddToScheme(scheme))
+}
+
+func main() {
+	var metricsAddr string
+	var probeAddr string
+	var enableLeaderElection bool
+	var leaderElectionNamespace string
+	var enableWebhook bool
+	var webhookPort int
+	var webhookCertDir string
+	var validator ccmwebhook.Validator
+
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
+	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&probeAddr, "health-probe-addr", ":8081", "The address the health and readiness probes bind to.")
+	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
+		"Enable leader election for controller manager. "+
+			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "",
+		"The namespace of the leader election lease, defaults to the namespace of the pod.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false, "Serve the validating and conversion webhooks of cluster config maps.")
+	flag.IntVar(&webhookPort, "webhook-port", webhook.DefaultPort, "The port the webhook server binds to.")
+	flag.StringVar(&webhookCertDir, "webhook-cert-dir", "",
+		"The directory holding the tls.crt and tls.key of the webhook server, defaults to the controller-runtime cert dir.")
+	flag.IntVar(&validator.MaxSize, "max-size", ccmwebhook.DefaultMaxSize,
+		"The maximum total size in bytes of the data and binary data of a cluster config map, 0 disables the cap.")
+	flag.Parse()
+	ctrl.SetLogger(zap.New(zap.UseDevMode(true), zap.UseFlagOptions(&opts)))
+
+	ctx := ctrl.SetupSignalHandler()
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+		Scheme:                  scheme,
+		Metrics:                 metricsserver.Options{BindAddress: metricsAddr},
+		HealthProbeBindAddress:  probeAddr,
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        "ccm-controller.indeed.com",
+		LeaderElectionNamespace: leaderElectionNamespace,
+		Cache:                   mirror.CacheOptions(),
+		WebhookServer:           webhook.NewServer(webhook.Options{Port: webhookPort, CertDir: webhookCertDir}),
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to create manager: "+err.Error())
+		os.Exit(1)
+	}
+
+	reconciler := &mirror.Reconciler{
+		Client:   mgr.GetClient(),
+		Recorder: mgr.GetEventRecorderFor("ccm-controller"),
+	}
+	if err := reconciler.SetupWithManager(ctx, mgr); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to create mirror controller: "+err.Error())
+		os.Exit(1)
+	}
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to add health check: "+err.Error())
+		os.Exit(1)
+	}
+	if err := mgr.AddReadyzCheck("ping", healthz.Ping); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to add readiness check: "+err.Error())
+		os.Exit(1)
+	}
+	if enableWebhook {
+		if err := validator.SetupWithManager(mgr); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to create validating webhook: "+err.Error())
+			os.Exit(1)
+		}
+		if err := ccmwebhook.SetupConversionWithManager(mgr); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to create conversion webhook: "+err.Error())
+			os.Exit(1)
+		}
+		if err := mgr.AddReadyzCheck("webhook", mgr.GetWebhookServer().StartedChecker()); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, "failed to add webhook readiness check: "+err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if err := mgr.Start(ctx); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to run manager: "+err.Error())
+		os.Exit(1)
+	}
+}
@@ -17,10 +17,13 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -36,12 +39,15 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
 )
 
 var (
 	scheme = runtime.NewScheme()
+	// version is set at build time with -ldflags "-X main.version=<version>"
+	version = "dev"
 )
 
 func init() {
@@ -53,34 +59,78 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var endpoint string
+	var shutdownTimeout time.Duration
+	var driverOpts ccm.Options
 
 	opts := zap.Options{}
 	opts.BindFlags(flag.CommandLine)
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
-		"Enable leader election for controller manager. "+
-			"Enabling this will ensure there is only one active controller manager.")
-	flag.StringVar(&endpoint, "endpoint", "unix:///var/lib/kubelet/plugins/clusterconfigmaps.indeed.com/csi.sock", "CSI endpoint")
+		"Deprecated: has no effect, the csi plugin runs on every node. Leader election is configured on the controller.")
+	flag.StringVar(&endpoint, "endpoint", "", "CSI endpoint, defaults to the socket in the kubelet plugin dir of the driver name.")
+	flag.StringVar(&driverOpts.DriverName, "driver-name", ccm.DefaultDriverName,
+		"The name of the csi driver, which must match the name of the CSIDriver object.")
+	flag.StringVar(&driverOpts.StorageDir, "storage-dir", ccm.DefaultStorageDir,
+		"The directory holding the data and metadata of published volumes.")
+	flag.StringVar(&driverOpts.Version, "version", version, "The vendor version reported by the csi driver.")
+	flag.DurationVar(&driverOpts.GCInterval, "gc-interval", 10*time.Minute,
+		"The interval between garbage collections of orphaned volume data and metadata, 0 disables periodic collection.")
+	flag.IntVar(&driverOpts.GCConcurrency, "gc-concurrency", 4,
+		"The number of volumes garbage collected in parallel.")
+	flag.BoolVar(&driverOpts.LiveReadFallback, "live-read-fallback", true,
+		"Read cluster config maps directly from the apiserver when they are missing from the node's cache.")
+	flag.BoolVar(&driverOpts.SnapshotFallback, "snapshot-fallback", false,
+		"Publish volumes from the last known good contents kept on the node when the apiserver is unreachable.")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 20*time.Second,
+		"How long to wait for in-flight requests to finish on shutdown, should be less than the pod's termination grace period.")
 	flag.Parse()
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true), zap.UseFlagOptions(&opts)))
+	if enableLeaderElection {
+		ctrl.Log.Info("--enable-leader-election is deprecated and has no effect on the csi plugin")
+	}
+	if endpoint == "" {
+		endpoint = ccm.DefaultEndpoint(driverOpts.DriverName)
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+
+	drv, err := ccm.NewDriver(endpoint, driverOpts)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to create csi driver: "+err.Error())
+		return
+	}
 
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(ccm.Metrics, promhttp.HandlerOpts{}))
+	mux.Handle("/healthz", http.StripPrefix("/healthz", &healthz.Handler{Checks: drv.LivenessChecks()}))
+	mux.Handle("/healthz/", http.StripPrefix("/healthz", &healthz.Handler{Checks: drv.LivenessChecks()}))
+	mux.Handle("/readyz", http.StripPrefix("/readyz", &healthz.Handler{Checks: drv.ReadinessChecks()}))
+	mux.Handle("/readyz/", http.StripPrefix("/readyz", &healthz.Handler{Checks: drv.ReadinessChecks()}))
+	metricsServer := &http.Server{Addr: metricsAddr, Handler: mux}
 	go func() {
-		mux := http.NewServeMux()
-		mux.Handle("/metrics", promhttp.HandlerFor(ccm.Metrics, promhttp.HandlerOpts{}))
-		err := http.ListenAndServe(metricsAddr, mux)
-		if err != nil {
+		err := metricsServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			_, _ = fmt.Fprintln(os.Stderr, "failed to serve metrics: "+err.Error())
 			os.Exit(2)
 		}
 	}()
 
-	drv, err := ccm.NewDriver(endpoint)
-	if err != nil {
-		_, _ = fmt.Fprintln(os.Stderr, "failed to create csi driver: "+err.Error())
-		return
-	}
+	// stop the driver on SIGTERM or SIGINT, draining in-flight requests so volumes are not left half populated
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		drv.Stop(shutdownTimeout)
+	}()
 	if err := drv.Run(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "failed to run csi driver: "+err.Error())
 		return
 	}
+	<-stopped
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "failed to stop metrics server: "+err.Error())
+	}
 }
@@ -61,3 +61,62 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+The directory on the host holding the data and metadata of published volumes, derived from the driver name unless set,
+so releases with different driver names never share it
+*/}}
+{{- define "cluster-config-maps.storageDir" -}}
+{{- if .Values.storageDir }}
+{{- .Values.storageDir }}
+{{- else if eq .Values.driverName "clusterconfigmaps.indeed.com" }}
+{{- "/mnt/csi-ccm-data" }}
+{{- else }}
+{{- printf "/mnt/csi-ccm-data-%s" .Values.driverName }}
+{{- end }}
+{{- end }}
+
+{{/*
+Create the name of the webhook service of the controller
+*/}}
+{{- define "cluster-config-maps.webhookService" -}}
+{{- printf "%s-webhook" (include "cluster-config-maps.fullname" .) }}
+{{- end }}
+
+{{/*
+Generate the certificate of the webhook server, reusing the certificate of previous releases. The certificate is
+generated once per release and kept in the values, so the webhook configuration and the CRD share the same CA.
+*/}}
+{{- define "cluster-config-maps.webhookCert" -}}
+{{- if not (hasKey .Values.controller.webhook "generatedCert") }}
+{{- $service := include "cluster-config-maps.webhookService" . }}
+{{- $secret := lookup "v1" "Secret" "kube-system" (printf "%s-cert" $service) }}
+{{- if $secret }}
+{{- $_ := set .Values.controller.webhook "generatedCert" (dict "ca" (index $secret.data "ca.crt") "cert" (index $secret.data "tls.crt") "key" (index $secret.data "tls.key")) }}
+{{- else }}
+{{- $ca := genCA (printf "%s-ca" $service) 3650 }}
+{{- $cert := genSignedCert (printf "%s.kube-system.svc" $service) nil (list $service (printf "%s.kube-system" $service) (printf "%s.kube-system.svc" $service)) 3650 $ca }}
+{{- $_ := set .Values.controller.webhook "generatedCert" (dict "ca" ($ca.Cert | b64enc) "cert" ($cert.Cert | b64enc) "key" ($cert.Key | b64enc)) }}
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{/*
+Convert the versions of the CRD through the conversion webhook of the controller
+*/}}
+{{- define "cluster-config-maps.crdConversion" -}}
+{{- if not (and .Values.controller.enabled .Values.controller.webhook.enabled) }}
+{{- fail "the CRD is converted between versions by the controller, installCRDs requires controller.enabled and controller.webhook.enabled" }}
+{{- end }}
+{{- include "cluster-config-maps.webhookCert" . -}}
+conversion:
+  strategy: Webhook
+  webhook:
+    clientConfig:
+      caBundle: {{ .Values.controller.webhook.generatedCert.ca }}
+      service:
+        name: {{ include "cluster-config-maps.webhookService" . }}
+        namespace: kube-system
+        path: /convert
+    conversionReviewVersions: ["v1"]
+{{- end }}
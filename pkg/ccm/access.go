@@ -0,0 +1,50 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// errPermissionDenied is returned when the namespace of the pod is not allowed to mount the cluster config map.
+var errPermissionDenied = errors.New("permission denied")
+
+// authorize checks the namespace of the pod is allowed to mount the cluster config map. Cluster config maps without
+// allowed namespaces or a namespace selector may be mounted from any namespace. The namespace is only read when it is
+// not allowed by name, and only its metadata is cached.
+func (n *nodePublisher) authorize(ctx context.Context, ccm *v1alpha1.ClusterConfigMap, namespace string) error {
+	if len(ccm.AllowedNamespaces) == 0 && ccm.NamespaceSelector == nil {
+		return nil
+	}
+	if namespace == "" {
+		return fmt.Errorf("%w: cluster configmap %q restricts namespaces, but the namespace of the pod is unknown", errPermissionDenied, ccm.Name)
+	}
+	if slices.Contains(ccm.AllowedNamespaces, namespace) {
+		return nil
+	}
+
+	if ccm.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(ccm.NamespaceSelector)
+		if err != nil {
+			return fmt.Errorf("%w: invalid namespace selector of cluster configmap %q: %s", errPermissionDenied, ccm.Name, err.Error())
+		}
+		ns := &metav1.PartialObjectMetadata{}
+		ns.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Namespace"))
+		if err := n.getObject(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return fmt.Errorf("failed to read namespace %q: %w", namespace, err)
+		}
+		if selector.Matches(labels.Set(ns.Labels)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: namespace %q is not allowed to mount cluster configmap %q", errPermissionDenied, namespace, ccm.Name)
+}
@@ -0,0 +1,166 @@
+package ccm
+
+import (
+	"context"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func Test_nodePublisher_authorize(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search", Labels: map[string]string{"team": "search"}}},
+	).Build()
+	publisher := &nodePublisher{cache: c, reader: c}
+
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}
+
+	type testcase struct {
+		description       string
+		allowedNamespaces []string
+		namespaceSelector *metav1.LabelSelector
+		namespace         string
+		allowed           bool
+	}
+	tests := []testcase{
+		{
+			description: "any namespace should be allowed without restrictions",
+			namespace:   "search",
+			allowed:     true,
+		},
+		{
+			description:       "a namespace in the allowed namespaces should be allowed",
+			allowedNamespaces: []string{"payments"},
+			namespace:         "payments",
+			allowed:           true,
+		},
+		{
+			description:       "a namespace missing from the allowed namespaces should be denied",
+			allowedNamespaces: []string{"payments"},
+			namespace:         "search",
+			allowed:           false,
+		},
+		{
+			description:       "a namespace matching the selector should be allowed",
+			namespaceSelector: selector,
+			namespace:         "payments",
+			allowed:           true,
+		},
+		{
+			description:       "a namespace not matching the selector should be denied",
+			namespaceSelector: selector,
+			namespace:         "search",
+			allowed:           false,
+		},
+		{
+			description:       "a namespace matching either the list or the selector should be allowed",
+			allowedNamespaces: []string{"search"},
+			namespaceSelector: selector,
+			namespace:         "search",
+			allowed:           true,
+		},
+		{
+			description:       "an unknown namespace should be denied when namespaces are restricted",
+			allowedNamespaces: []string{"payments"},
+			namespace:         "",
+			allowed:           false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ccm := &v1alpha1.ClusterConfigMap{
+				ObjectMeta:        metav1.ObjectMeta{Name: "test-cluster-config-maps"},
+				AllowedNamespaces: test.allowedNamespaces,
+				NamespaceSelector: test.namespaceSelector,
+			}
+			err := publisher.authorize(context.Background(), ccm, test.namespace)
+			if test.allowed {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, errPermissionDenied)
+			}
+		})
+	}
+}
+
+func Test_nodePublisher_authorize_v1beta1(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, corev1.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search", Labels: map[string]string{"team": "search"}}},
+	).Build()
+	publisher := &nodePublisher{cache: c, reader: c}
+
+	// access policies are written as v1beta1, and read by the node plugin as v1alpha1 through the conversion webhook
+	type testcase struct {
+		description string
+		access      *v1beta1.AccessPolicy
+		allowed     []string
+		denied      []string
+	}
+	tests := []testcase{
+		{
+			description: "every namespace should be allowed without an access policy",
+			allowed:     []string{"payments", "search"},
+		},
+		{
+			description: "only the allowed namespaces should be allowed",
+			access:      &v1beta1.AccessPolicy{AllowedNamespaces: []string{"payments"}},
+			allowed:     []string{"payments"},
+			denied:      []string{"search"},
+		},
+		{
+			description: "only namespaces matching the selector should be allowed",
+			access: &v1beta1.AccessPolicy{
+				AllowedNamespaces: []string{},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}},
+			},
+			allowed: []string{"search"},
+			denied:  []string{"payments"},
+		},
+		{
+			description: "namespaces listed or matching the selector should be allowed",
+			access: &v1beta1.AccessPolicy{
+				AllowedNamespaces: []string{"payments"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "search"}},
+			},
+			allowed: []string{"payments", "search"},
+		},
+		{
+			description: "an empty selector should allow every namespace",
+			access:      &v1beta1.AccessPolicy{NamespaceSelector: &metav1.LabelSelector{}},
+			allowed:     []string{"payments", "search"},
+		},
+	}
+
+	for _, test := range tests {
+		hub := &v1beta1.ClusterConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+			Spec:       v1beta1.ClusterConfigMapSpec{Access: test.access},
+		}
+		ccm := &v1alpha1.ClusterConfigMap{}
+		require.NoError(t, ccm.ConvertFrom(hub), test.description)
+		for _, namespace := range test.allowed {
+			require.NoError(t, publisher.authorize(context.TODO(), ccm, namespace), test.description)
+		}
+		for _, namespace := range test.denied {
+			require.ErrorIs(t, publisher.authorize(context.TODO(), ccm, namespace), errPermissionDenied, test.description)
+		}
+	}
+}
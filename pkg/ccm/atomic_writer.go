@@ -0,0 +1,258 @@
+package ccm
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// dataDirName is the symlink pointing at the timestamped directory holding the current volume contents.
+	dataDirName = "..data"
+	// newDataDirName is the temporary symlink renamed over dataDirName to switch contents atomically.
+	newDataDirName = "..data_tmp"
+)
+
+// fileProjection is the content, permissions and ownership of a single file written by the atomicWriter.
+type fileProjection struct {
+	Data []byte
+	Mode os.FileMode
+	// UID and GID change the ownership of the file when set, it is left unchanged otherwise.
+	UID *int64
+	GID *int64
+}
+
+// atomicWriter writes a set of files into a target directory so that readers never observe a partially written or
+// mixed set of files, following the same scheme kubelet uses for config map and secret volumes:
+//
+//	<target>/..2006_01_02_15_04_05.123456789/<files>  timestamped directory with the actual contents
+//	<target>/..data -> ..2006_01_02_15_04_05.123456789  symlink swapped atomically on every write
+//	<target>/<file> -> ..data/<file>                    per-key symlinks visible to the consumer
+//
+// New contents are written to a fresh timestamped directory, and the ..data symlink is then replaced with a rename,
+// which is atomic on posix filesystems. Files from the previous write are removed once the swap has completed.
+type atomicWriter struct {
+	targetDir string
+	// uid and gid change the ownership of the target directory and the directories holding its contents when set.
+	uid *int64
+	gid *int64
+}
+
+// Write atomically replaces the contents of the target directory with the payload, keyed by the relative file path.
+func (w *atomicWriter) Write(payload map[string]fileProjection) error {
+	for relPath := range payload {
+		if err := validatePath(relPath); err != nil {
+			return err
+		}
+	}
+
+	dataDirPath := path.Join(w.targetDir, dataDirName)
+	oldTsDir, err := os.Readlink(dataDirPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read data dir link %q: %w", dataDirPath, err)
+	}
+
+	if err := w.chownDir(w.targetDir); err != nil {
+		return err
+	}
+	tsDir, err := w.newTimestampDir()
+	if err != nil {
+		return err
+	}
+	if err := w.writePayload(tsDir, payload); err != nil {
+		_ = os.RemoveAll(tsDir)
+		return err
+	}
+	if err := w.chownDirs(tsDir); err != nil {
+		_ = os.RemoveAll(tsDir)
+		return err
+	}
+
+	newDataDirPath := path.Join(w.targetDir, newDataDirName)
+	if err := os.Remove(newDataDirPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove stale data dir link %q: %w", newDataDirPath, err)
+	}
+	if err := os.Symlink(filepath.Base(tsDir), newDataDirPath); err != nil {
+		_ = os.RemoveAll(tsDir)
+		return fmt.Errorf("failed to create data dir link %q: %w", newDataDirPath, err)
+	}
+	if err := os.Rename(newDataDirPath, dataDirPath); err != nil {
+		_ = os.Remove(newDataDirPath)
+		_ = os.RemoveAll(tsDir)
+		return fmt.Errorf("failed to swap data dir link %q: %w", dataDirPath, err)
+	}
+
+	if err := w.createUserVisibleLinks(payload); err != nil {
+		return err
+	}
+	if err := w.removeUserVisibleLinks(payload); err != nil {
+		return err
+	}
+
+	if oldTsDir != "" && oldTsDir != filepath.Base(tsDir) {
+		oldTsPath := path.Join(w.targetDir, oldTsDir)
+		if err := os.RemoveAll(oldTsPath); err != nil {
+			return fmt.Errorf("failed to remove previous data dir %q: %w", oldTsPath, err)
+		}
+	}
+	return nil
+}
+
+// newTimestampDir creates a new timestamped directory to hold the next set of contents.
+func (w *atomicWriter) newTimestampDir() (string, error) {
+	tsDir, err := os.MkdirTemp(w.targetDir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return "", fmt.Errorf("failed to create timestamped data dir in %q: %w", w.targetDir, err)
+	}
+	// MkdirTemp always creates the dir with 0700, consumers need to be able to traverse it
+	if err := os.Chmod(tsDir, 0755); err != nil {
+		_ = os.RemoveAll(tsDir)
+		return "", fmt.Errorf("failed to set permissions of timestamped data dir %q: %w", tsDir, err)
+	}
+	return tsDir, nil
+}
+
+// writePayload writes every file of the payload into the timestamped directory.
+func (w *atomicWriter) writePayload(tsDir string, payload map[string]fileProjection) error {
+	for relPath, content := range payload {
+		target := path.Join(tsDir, relPath)
+		if err := os.MkdirAll(path.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create parent dir of %q: %w", target, err)
+		}
+		logger.V(5).Info("writing data to target " + target)
+		if err := os.WriteFile(target, content.Data, content.Mode); err != nil {
+			return fmt.Errorf("failed to write %q: %w", target, err)
+		}
+		// the mode passed to WriteFile is subject to the umask, set it explicitly
+		if err := os.Chmod(target, content.Mode); err != nil {
+			return fmt.Errorf("failed to set mode of %q: %w", target, err)
+		}
+		if content.UID != nil || content.GID != nil {
+			if err := os.Chown(target, idOrUnchanged(content.UID), idOrUnchanged(content.GID)); err != nil {
+				return fmt.Errorf("failed to set ownership of %q: %w", target, err)
+			}
+		}
+	}
+	return nil
+}
+
+// chownDirs changes the ownership of the timestamped directory and every directory nested in it.
+func (w *atomicWriter) chownDirs(tsDir string) error {
+	if w.uid == nil && w.gid == nil {
+		return nil
+	}
+	return filepath.WalkDir(tsDir, func(dirPath string, dirEntry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !dirEntry.IsDir() {
+			return nil
+		}
+		return w.chownDir(dirPath)
+	})
+}
+
+// chownDir changes the ownership of a single directory, if an owner is set.
+func (w *atomicWriter) chownDir(dirPath string) error {
+	if w.uid == nil && w.gid == nil {
+		return nil
+	}
+	if err := os.Chown(dirPath, idOrUnchanged(w.uid), idOrUnchanged(w.gid)); err != nil {
+		return fmt.Errorf("failed to set ownership of %q: %w", dirPath, err)
+	}
+	return nil
+}
+
+// createUserVisibleLinks links the top level path of every file in the payload through the ..data symlink.
+func (w *atomicWriter) createUserVisibleLinks(payload map[string]fileProjection) error {
+	for name := range topLevelNames(payload) {
+		visiblePath := path.Join(w.targetDir, name)
+		info, err := os.Lstat(visiblePath)
+		if err == nil {
+			if info.Mode()&os.ModeSymlink != 0 {
+				continue
+			}
+			// volumes populated before the atomic writer hold plain files, replace them with links
+			if err := os.RemoveAll(visiblePath); err != nil {
+				return fmt.Errorf("failed to remove legacy file %q: %w", visiblePath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to stat %q: %w", visiblePath, err)
+		}
+		if err := os.Symlink(path.Join(dataDirName, name), visiblePath); err != nil {
+			return fmt.Errorf("failed to link %q: %w", visiblePath, err)
+		}
+	}
+	return nil
+}
+
+// removeUserVisibleLinks removes the user visible paths which are no longer part of the payload.
+func (w *atomicWriter) removeUserVisibleLinks(payload map[string]fileProjection) error {
+	dirEntries, err := os.ReadDir(w.targetDir)
+	if err != nil {
+		return fmt.Errorf("failed to list dir entries for %q: %w", w.targetDir, err)
+	}
+	names := topLevelNames(payload)
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if strings.HasPrefix(name, "..") || names[name] {
+			continue
+		}
+		stalePath := path.Join(w.targetDir, name)
+		logger.V(5).Info("removing stale data from target " + stalePath)
+		if err := os.RemoveAll(stalePath); err != nil {
+			return fmt.Errorf("failed to remove stale path %q: %w", stalePath, err)
+		}
+	}
+	return nil
+}
+
+// idOrUnchanged returns the id, or -1 which leaves the id unchanged in chown.
+func idOrUnchanged(id *int64) int {
+	if id == nil {
+		return -1
+	}
+	return int(*id)
+}
+
+// validatePath ensures the relative path of a file stays within the target directory, and does not collide with the
+// paths reserved by the atomic writer.
+func validatePath(relPath string) error {
+	if relPath == "" {
+		return fmt.Errorf("%w: path must not be empty", errInvalidKey)
+	}
+	if path.IsAbs(relPath) {
+		return fmt.Errorf("%w: path %q must be relative", errInvalidKey, relPath)
+	}
+	if len(relPath) > 4096 {
+		return fmt.Errorf("%w: path %q must be less than or equal to 4096 characters", errInvalidKey, relPath)
+	}
+	// paths which are not clean would collide with their clean path, or fail to be written
+	for _, element := range strings.Split(relPath, "/") {
+		if element == ".." {
+			return fmt.Errorf("%w: path %q must not contain '..'", errInvalidKey, relPath)
+		}
+		if element == "." || element == "" {
+			return fmt.Errorf("%w: path %q must not contain '.' or empty elements", errInvalidKey, relPath)
+		}
+	}
+	if strings.HasPrefix(relPath, "..") {
+		return fmt.Errorf("%w: path %q must not start with '..'", errInvalidKey, relPath)
+	}
+	if path.Clean(relPath) != relPath {
+		return fmt.Errorf("%w: path %q must be clean", errInvalidKey, relPath)
+	}
+	return nil
+}
+
+// topLevelNames returns the first path element of every file in the payload.
+func topLevelNames(payload map[string]fileProjection) map[string]bool {
+	names := make(map[string]bool, len(payload))
+	for relPath := range payload {
+		names[strings.SplitN(relPath, "/", 2)[0]] = true
+	}
+	return names
+}
@@ -0,0 +1,89 @@
+package ccm
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readTarget(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	dirEntries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	contents := map[string]string{}
+	for _, dirEntry := range dirEntries {
+		if dirEntry.Name()[0] == '.' {
+			continue
+		}
+		bytes, err := os.ReadFile(path.Join(dir, dirEntry.Name()))
+		require.NoError(t, err)
+		contents[dirEntry.Name()] = string(bytes)
+	}
+	return contents
+}
+
+func Test_AtomicWriter_Write(t *testing.T) {
+	dir := t.TempDir()
+	writer := &atomicWriter{targetDir: dir}
+
+	require.NoError(t, writer.Write(map[string]fileProjection{
+		"foo.txt": {Data: []byte("foo"), Mode: 0644},
+		"bar.txt": {Data: []byte("bar"), Mode: 0600},
+	}))
+	require.Equal(t, map[string]string{"foo.txt": "foo", "bar.txt": "bar"}, readTarget(t, dir))
+
+	link, err := os.Readlink(path.Join(dir, "foo.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "..data/foo.txt", link)
+
+	info, err := os.Stat(path.Join(dir, "bar.txt"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	firstTsDir, err := os.Readlink(path.Join(dir, dataDirName))
+	require.NoError(t, err)
+
+	require.NoError(t, writer.Write(map[string]fileProjection{
+		"foo.txt": {Data: []byte("updated"), Mode: 0644},
+		"baz.txt": {Data: []byte("baz"), Mode: 0644},
+	}), "rewriting the volume should succeed")
+	require.Equal(t, map[string]string{"foo.txt": "updated", "baz.txt": "baz"}, readTarget(t, dir))
+
+	_, err = os.Stat(path.Join(dir, firstTsDir))
+	require.True(t, os.IsNotExist(err), "previous timestamped dir should be removed")
+	_, err = os.Lstat(path.Join(dir, newDataDirName))
+	require.True(t, os.IsNotExist(err), "temporary data dir link should not be left behind")
+}
+
+func Test_AtomicWriter_Write_LegacyFiles(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(path.Join(dir, "foo.txt"), []byte("legacy"), 0644))
+	require.NoError(t, os.WriteFile(path.Join(dir, "removed.txt"), []byte("legacy"), 0644))
+
+	writer := &atomicWriter{targetDir: dir}
+	require.NoError(t, writer.Write(map[string]fileProjection{
+		"foo.txt": {Data: []byte("foo"), Mode: 0644},
+	}))
+	require.Equal(t, map[string]string{"foo.txt": "foo"}, readTarget(t, dir))
+
+	info, err := os.Lstat(path.Join(dir, "foo.txt"))
+	require.NoError(t, err)
+	require.NotZero(t, info.Mode()&os.ModeSymlink, "legacy files should be replaced with links")
+}
+
+func Test_AtomicWriter_Write_InvalidPath(t *testing.T) {
+	for _, relPath := range []string{"../escape", "/etc/passwd", "foo/../../escape", "..data", "", ".", "./foo", "foo/./bar", "foo//bar", "foo/"} {
+		dir := t.TempDir()
+		writer := &atomicWriter{targetDir: dir}
+		err := writer.Write(map[string]fileProjection{
+			relPath: {Data: []byte("foo"), Mode: 0644},
+		})
+		require.ErrorIs(t, err, errInvalidKey, relPath)
+
+		dirEntries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		require.Empty(t, dirEntries, "nothing should be written for invalid path %q", relPath)
+	}
+}
@@ -1,114 +1,210 @@
 package ccm
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path"
+	"sync"
 	"time"
 
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/mount-utils"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func doCleanup() {
-	start := time.Now()
-	if err := cleanupDataDir(); err != nil {
-		logger.Error(err, "failed to cleanup")
-	}
-	if err := cleanupMetadataDir(); err != nil {
-		logger.Error(err, "failed to cleanup metadata")
+// garbageCollector deletes the data of volumes which do not have any mounts bound to them, and the metadata of volumes
+// without data. This catches volumes which were not removed by the node unpublish request, because its possible we
+// missed some requests, the daemon could have been down and the node out of sync of the cluster, etc.
+// It runs once at startup, and periodically afterward so orphaned volumes do not pile up on long-lived nodes.
+type garbageCollector struct {
+	volumes *volumeStore
+	mounter mount.Interface
+	// concurrency limits the number of volumes collected in parallel.
+	concurrency int
+	// tryLock marks the volume as busy, returning false if it is held by a volume request. Busy volumes are skipped
+	// until the next collection, as they may be in the middle of being published.
+	tryLock func(volumeID string) bool
+	unlock  func(volumeID string)
+	// snapshots, if set, holds the snapshots of cluster config maps, which are deleted once their cluster config map
+	// no longer exists according to the reader.
+	snapshots *snapshotStore
+	reader    client.Reader
+}
+
+// Start collects garbage at every interval until the context is done.
+func (c *garbageCollector) Start(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Collect(ctx)
+		}
 	}
-	cleanupTime.WithLabelValues().Observe(time.Since(start).Seconds())
 }
 
-// cleanupDataDir walks the contents of the data storage directory, and deletes any volume directories that
-// do not have any mounts bound to them. This is more reliable than deleting just the volume at the time of the
-// node unpublish request, because its possible we missed some requests, the daemon could have been down and the node
-// out of sync of the cluster, etc.
-func cleanupDataDir() error {
-	dataDir := path.Join(storageDir, "data")
-	dirEntries, err := os.ReadDir(dataDir)
+// Collect deletes the data and metadata of every orphaned volume in the storage directory, and the snapshots of
+// deleted cluster config maps.
+func (c *garbageCollector) Collect(ctx context.Context) {
+	start := time.Now()
+	defer func() {
+		cleanupTime.WithLabelValues().Observe(time.Since(start).Seconds())
+	}()
+
+	c.collectSnapshots(ctx)
+	volumeIDs, err := c.volumes.listVolumes()
 	if err != nil {
-		if os.IsNotExist(err) {
-			logger.Info("[cleanup] no data dir, skipping cleanup")
-			return nil
-		}
-		return fmt.Errorf("failed to list dir entries for %q: %w", dataDir, err)
+		logger.Error(err, "failed to cleanup")
+		return
 	}
 
-	mounter := mount.New("")
-	for _, dirEntry := range dirEntries {
-		if !dirEntry.IsDir() {
-			// we shouldn't have any bare files here
-			logger.Info("[cleanup] unexpected file in data dir: " + path.Join(dataDir, dirEntry.Name()))
-			cleanupErr.WithLabelValues("unexpected file in metadata dir").Inc()
-			continue
+	concurrency := max(c.concurrency, 1)
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for _, volumeID := range volumeIDs {
+		if ctx.Err() != nil {
+			break
 		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			c.collectVolume(volumeID)
+		}()
+	}
+	wg.Wait()
+}
 
-		volumeID := dirEntry.Name()
-		dataPath := path.Join(dataDir, volumeID)
+// collectVolume deletes the data dir of the volume if no mounts are bound to it, and its metadata once the data dir is
+// gone. Deleting the data dir signals that it is safe to remove the volume metadata.
+func (c *garbageCollector) collectVolume(volumeID string) {
+	if !c.tryLock(volumeID) {
+		logger.V(6).Info(fmt.Sprintf("[cleanup] volume %q is busy, skipping...", volumeID))
+		gcSkipped.WithLabelValues().Inc()
+		return
+	}
+	defer c.unlock(volumeID)
+
+	dataPath := c.volumes.DataPath(volumeID)
+	_, err := os.Stat(dataPath)
+	if err == nil {
 		logger.V(6).Info("[cleanup] checking mount state of " + dataPath)
-		refs, err := mounter.GetMountRefs(dataPath)
+		refs, err := c.mounter.GetMountRefs(dataPath)
 		if err != nil {
 			logger.Error(err, "cleanup failed to lookup refs for "+dataPath+" - skipping...")
 			cleanupErr.WithLabelValues("error listing mount refs").Inc()
-			continue
+			return
 		}
-		if len(refs) == 0 {
+		if len(refs) > 0 {
 			logger.V(6).Info(fmt.Sprintf("[cleanup] mount refs for %s: %v", dataPath, refs))
-			// deleting the dataPath directory signals that it is safe to remove the volume metadata
-			if err := os.RemoveAll(dataPath); err != nil {
-				logger.Error(err, "cleanup failed to delete "+dataPath+" - skipping...")
-				cleanupErr.WithLabelValues("removing metadata dir failed").Inc()
-				continue
-			}
-			logger.V(6).Info(fmt.Sprintf("[cleanup] deleted %s successfully", dataPath))
+			return
+		}
+		usedBytes, _, err := diskUsage(dataPath)
+		if err != nil {
+			logger.V(6).Info(fmt.Sprintf("[cleanup] failed to measure %s: %s", dataPath, err.Error()))
+		}
+		if err := os.RemoveAll(dataPath); err != nil {
+			logger.Error(err, "cleanup failed to delete "+dataPath+" - skipping...")
+			cleanupErr.WithLabelValues("removing data dir failed").Inc()
+			return
+		}
+		gcOrphans.WithLabelValues("data").Inc()
+		gcReclaimedBytes.WithLabelValues().Add(float64(usedBytes))
+		logger.V(6).Info(fmt.Sprintf("[cleanup] deleted %s successfully", dataPath))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		logger.Error(err, fmt.Sprintf("[cleanup] unexpected error stating data path for volume %q", volumeID))
+		cleanupErr.WithLabelValues("unexpected error stating data dir").Inc()
+		return
+	}
+
+	metadataPath := c.volumes.MetadataPath(volumeID)
+	if _, err := os.Stat(metadataPath); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Error(err, fmt.Sprintf("[cleanup] unexpected error stating metadata path for volume %q", volumeID))
+			cleanupErr.WithLabelValues("unexpected error stating metadata dir").Inc()
 		}
+		return
+	}
+	logger.V(6).Info(fmt.Sprintf("[cleanup] removing metadata for %q", metadataPath))
+	if err := os.RemoveAll(metadataPath); err != nil {
+		logger.Error(err, "cleanup failed to delete "+metadataPath+" - skipping...")
+		cleanupErr.WithLabelValues("removing metadata dir failed").Inc()
+		return
 	}
-	return nil
+	gcOrphans.WithLabelValues("metadata").Inc()
+	logger.V(6).Info(fmt.Sprintf("[cleanup] deleted %s successfully", metadataPath))
 }
 
-// cleanupMetadataDir walks the contents of the metadata storage directory, and deletes any metadata directories that
-// do not have a corresponding volume mount directory.
-func cleanupMetadataDir() error {
-	metadataDir := path.Join(storageDir, "metadata")
-	dirEntries, err := os.ReadDir(metadataDir)
+// collectSnapshots deletes the snapshots of cluster config maps which no longer exist. Snapshots are kept whenever the
+// cluster config map can not be read, as they are needed the most while the apiserver is unreachable.
+func (c *garbageCollector) collectSnapshots(ctx context.Context) {
+	if c.snapshots == nil {
+		return
+	}
+	names, err := c.snapshots.List()
 	if err != nil {
-		return fmt.Errorf("failed to list dir entries for %q: %w", metadataDir, err)
+		logger.Error(err, "[cleanup] failed to list snapshots")
+		cleanupErr.WithLabelValues("error listing snapshots").Inc()
+		return
 	}
-	dataDir := path.Join(storageDir, "data")
-
-	for _, dirEntry := range dirEntries {
-		if !dirEntry.IsDir() {
-			// we shouldn't have any bare files here
-			logger.Info("[cleanup] unexpected file in metadata dir: " + path.Join(metadataDir, dirEntry.Name()))
-			cleanupErr.WithLabelValues("unexpected file in metadata dir").Inc()
-			continue
+	for _, name := range names {
+		if ctx.Err() != nil {
+			return
 		}
-
-		volumeID := dirEntry.Name()
-		metadataPath := path.Join(metadataDir, volumeID)
-
-		// if the data dir associated with this volume still exists, assume the data is still being used
-		dataPath := path.Join(dataDir, volumeID)
-		_, err := os.Stat(dataPath)
-		if err == nil {
-			logger.V(6).Info(fmt.Sprintf("[cleanup] metadata for %s appears to be in use, no cleanup necessary", metadataPath))
+		err := c.reader.Get(ctx, client.ObjectKey{Name: name}, &v1alpha1.ClusterConfigMap{})
+		if !apierrors.IsNotFound(err) {
+			if err != nil {
+				logger.V(6).Info(fmt.Sprintf("[cleanup] failed to read cluster config map %q, keeping its snapshot: %s", name, err.Error()))
+			}
 			continue
 		}
-		if !errors.Is(err, fs.ErrNotExist) {
-			logger.Error(err, fmt.Sprintf("[cleanup] unexpected error stating data path for volume %q", volumeID))
-			cleanupErr.WithLabelValues("unexpected error stating metadata dir").Inc()
+		if err := c.snapshots.Delete(name); err != nil {
+			logger.Error(err, fmt.Sprintf("[cleanup] failed to delete snapshot of %q - skipping...", name))
+			cleanupErr.WithLabelValues("removing snapshot failed").Inc()
 			continue
 		}
-		logger.V(6).Info(fmt.Sprintf("[cleanup] removing metadata for %q", metadataPath))
-		if err := os.RemoveAll(metadataPath); err != nil {
-			logger.Error(err, "cleanup failed to delete "+metadataPath+" - skipping...")
-			cleanupErr.WithLabelValues("removing metadata dir failed").Inc()
-			continue
+		gcOrphans.WithLabelValues("snapshot").Inc()
+		logger.V(6).Info(fmt.Sprintf("[cleanup] deleted snapshot of deleted cluster config map %q", name))
+	}
+}
+
+// listVolumes returns the ids of every volume with a data or metadata dir in the storage directory.
+func (s *volumeStore) listVolumes() ([]string, error) {
+	var volumeIDs []string
+	seen := map[string]bool{}
+	for _, kind := range []string{"data", "metadata"} {
+		dir := path.Join(s.dir, kind)
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				logger.Info(fmt.Sprintf("[cleanup] no %s dir, skipping cleanup", kind))
+				continue
+			}
+			return nil, fmt.Errorf("failed to list dir entries for %q: %w", dir, err)
+		}
+		for _, dirEntry := range dirEntries {
+			if !dirEntry.IsDir() {
+				// we shouldn't have any bare files here
+				logger.Info(fmt.Sprintf("[cleanup] unexpected file in %s dir: %s", kind, path.Join(dir, dirEntry.Name())))
+				cleanupErr.WithLabelValues(fmt.Sprintf("unexpected file in %s dir", kind)).Inc()
+				continue
+			}
+			if !seen[dirEntry.Name()] {
+				seen[dirEntry.Name()] = true
+				volumeIDs = append(volumeIDs, dirEntry.Name())
+			}
 		}
-		logger.V(6).Info(fmt.Sprintf("[cleanup] deleted %s successfully", metadataPath))
 	}
-	return nil
+	return volumeIDs, nil
 }
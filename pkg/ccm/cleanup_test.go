@@ -0,0 +1,97 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/mount-utils"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_garbageCollector_Collect(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	dir := volumes.dir
+	for _, volumeID := range []string{"mounted-volume-id", "unmounted-volume-id", "busy-volume-id"} {
+		meta := &ClusterConfigMapMeta{Name: "test-cluster-config-maps", VolumeID: volumeID}
+		require.NoError(t, volumes.WriteMetadata(meta))
+		dataDir, err := volumes.DataDir(meta.VolumeID)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(path.Join(dataDir, "foo.txt"), []byte("foo"), 0644))
+	}
+	orphaned := &ClusterConfigMapMeta{Name: "test-cluster-config-maps", VolumeID: "orphaned-volume-id"}
+	require.NoError(t, volumes.WriteMetadata(orphaned))
+
+	mounter := mount.NewFakeMounter(nil)
+	require.NoError(t, mounter.Mount(volumes.DataPath("mounted-volume-id"), path.Join(t.TempDir(), "target"), "ext4", []string{"bind"}))
+
+	driver := newDriver("test", "", volumes, &mockVolumePublisher{})
+	driver.mounter = mounter
+	driver.gcConcurrency = 2
+	require.True(t, driver.tryLockVolume("busy-volume-id"))
+	driver.garbageCollector().Collect(context.TODO())
+
+	exists := func(elem ...string) bool {
+		_, err := os.Stat(path.Join(append([]string{dir}, elem...)...))
+		return err == nil
+	}
+	require.True(t, exists("data", "mounted-volume-id"), "data of mounted volumes should be kept")
+	require.True(t, exists("metadata", "mounted-volume-id"), "metadata of mounted volumes should be kept")
+	require.False(t, exists("data", "unmounted-volume-id"), "data of unmounted volumes should be removed")
+	require.False(t, exists("metadata", "unmounted-volume-id"), "metadata of unmounted volumes should be removed")
+	require.False(t, exists("metadata", "orphaned-volume-id"), "metadata without data should be removed")
+	require.True(t, exists("data", "busy-volume-id"), "data of busy volumes should be skipped")
+	require.True(t, exists("metadata", "busy-volume-id"), "metadata of busy volumes should be skipped")
+
+	driver.unlockVolume("busy-volume-id")
+	driver.garbageCollector().Collect(context.TODO())
+	require.False(t, exists("data", "busy-volume-id"), "busy volumes should be collected once they are released")
+	require.False(t, exists("metadata", "busy-volume-id"), "busy volumes should be collected once they are released")
+}
+
+func Test_garbageCollector_Collect_Snapshots(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	snapshots := newSnapshotStore(t.TempDir())
+	for _, name := range []string{"existing-ccm", "deleted-ccm", "unreachable-ccm"} {
+		require.NoError(t, snapshots.Save(&v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, ResourceVersion: "1"}}))
+	}
+	reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "existing-ccm"}},
+	).WithInterceptorFuncs(interceptor.Funcs{
+		Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+			if key.Name == "unreachable-ccm" {
+				return errors.New("connection refused")
+			}
+			return c.Get(ctx, key, obj, opts...)
+		},
+	}).Build()
+
+	driver := newDriver("test", "", volumes, &mockVolumePublisher{})
+	driver.mounter = mount.NewFakeMounter(nil)
+	driver.apiReader = reader
+	driver.snapshots = snapshots
+	driver.garbageCollector().Collect(context.TODO())
+
+	names, err := snapshots.List()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"existing-ccm", "unreachable-ccm"}, names,
+		"only snapshots of cluster config maps known to be deleted should be removed")
+
+	require.NoError(t, snapshots.Save(&v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "deleted-ccm", ResourceVersion: "1"}}))
+	_, err = snapshots.Load("deleted-ccm")
+	require.NoError(t, err, "a snapshot should be saved again once its cluster config map is recreated")
+}
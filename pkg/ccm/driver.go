@@ -2,25 +2,53 @@ package ccm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/mount-utils"
 
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 var logger = ctrl.Log.WithName("driver")
 
+const (
+	// DefaultDriverName is the name the csi driver registers with kubelet unless configured otherwise.
+	DefaultDriverName = "clusterconfigmaps.indeed.com"
+	// DefaultStorageDir is the directory holding the data and metadata of volumes unless configured otherwise.
+	DefaultStorageDir = "/csi-ccm-data"
+)
+
+// DefaultEndpoint returns the csi endpoint kubelet expects for the named driver, the socket in its plugin dir.
+func DefaultEndpoint(driverName string) string {
+	return "unix:///var/lib/kubelet/plugins/" + driverName + "/csi.sock"
+}
+
 // Driver implements the following CSI interfaces:
 //
 //	csi.IdentityServer
@@ -28,55 +56,169 @@ var logger = ctrl.Log.WithName("driver")
 type driver struct {
 	nodeID   string
 	endpoint string
+	name     string
+	version  string
 
+	volumes   *volumeStore
 	publisher VolumePublisher
+	mounter   mount.Interface
+	apiReader ctrlclient.Reader
+	snapshots *snapshotStore
+	watcher   *configMapWatcher
+	status    *statusReporter
 
+	// recorder posts events on pods and cluster config maps, events are disabled when nil.
+	recorder    record.EventRecorder
+	broadcaster record.EventBroadcaster
+
+	// volumeBusy holds a channel for every volume with a request or refresh in progress, closed once it is released.
 	volumeLock sync.Mutex
-	volumeBusy map[string]bool
+	volumeBusy map[string]chan struct{}
+	publishes  singleflight.Group
+
+	gcInterval    time.Duration
+	gcConcurrency int
 
-	srv *grpc.Server
+	// runLock guards the server and the cancel func of background work, which are created by Run and used by Stop
+	// from another goroutine. stopped records a Stop which happened before Run created them.
+	runLock sync.Mutex
+	stopped bool
+	srv     *grpc.Server
+	cancel  context.CancelFunc
 }
 
-func newDriver(nodeID, endpoint string, publisher VolumePublisher) *driver {
+func newDriver(nodeID, endpoint string, volumes *volumeStore, publisher VolumePublisher) *driver {
 	return &driver{
 		nodeID:     nodeID,
 		endpoint:   endpoint,
+		name:       DefaultDriverName,
+		version:    "dev",
+		volumes:    volumes,
 		publisher:  publisher,
-		volumeBusy: make(map[string]bool),
+		mounter:    mount.New(""),
+		volumeBusy: make(map[string]chan struct{}),
+	}
+}
+
+// garbageCollector collects the orphaned volumes of the driver, skipping volumes with requests in progress, and the
+// snapshots of deleted cluster config maps.
+func (d *driver) garbageCollector() *garbageCollector {
+	return &garbageCollector{
+		volumes:     d.volumes,
+		mounter:     d.mounter,
+		concurrency: d.gcConcurrency,
+		tryLock:     d.tryLockVolume,
+		unlock:      d.unlockVolume,
+		snapshots:   d.snapshots,
+		reader:      d.apiReader,
 	}
 }
 
-func NewDriver(endpoint string) (*driver, error) {
+// Options configures the behavior of the csi driver.
+type Options struct {
+	// LiveReadFallback reads cluster config maps directly from the apiserver when they are missing from the cache.
+	LiveReadFallback bool
+	// SnapshotFallback keeps the last known good contents of cluster config maps on the node, and publishes volumes
+	// from them when the cluster config map can not be read from the cache or the apiserver.
+	SnapshotFallback bool
+	// StorageDir is the directory holding the data and metadata of volumes, defaults to DefaultStorageDir.
+	StorageDir string
+	// DriverName is the name the csi driver reports to kubelet, defaults to DefaultDriverName. Running drivers with
+	// different names and storage dirs allows them to run side by side on the same node.
+	DriverName string
+	// Version is the vendor version the csi driver reports to kubelet.
+	Version string
+	// GCInterval is the interval between garbage collections of orphaned volumes, which always run once at startup.
+	// Periodic garbage collection is disabled when zero.
+	GCInterval time.Duration
+	// GCConcurrency is the number of volumes garbage collected in parallel.
+	GCConcurrency int
+}
+
+func NewDriver(endpoint string, opts Options) (*driver, error) {
 	host, _ := os.Hostname()
+	storageDir := DefaultStorageDir
+	if opts.StorageDir != "" {
+		storageDir = opts.StorageDir
+	}
+	volumes := newVolumeStore(storageDir)
 
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
-	client, err := kubernetes.NewForConfig(config)
-	if err != nil {
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
 		return nil, err
 	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	ccmCache, err := cache.New(config, cache.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster config map cache: %w", err)
+	}
+	apiReader, err := ctrlclient.New(config, ctrlclient.Options{Scheme: scheme})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster config map client: %w", err)
+	}
+	// reads are served from the cache, writes go to the apiserver
+	ccmClient, err := ctrlclient.New(config, ctrlclient.Options{
+		Scheme: scheme,
+		Cache:  &ctrlclient.CacheOptions{Reader: ccmCache},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster config map client: %w", err)
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create kubernetes client: %w", err)
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	driverName := DefaultDriverName
+	if opts.DriverName != "" {
+		driverName = opts.DriverName
+	}
+	recorder := broadcaster.NewRecorder(scheme, corev1.EventSource{Component: driverName, Host: host})
 
-	return newDriver(host, endpoint, &nodePublisher{client: client}), nil
+	mounter := mount.New("")
+	publisher := &nodePublisher{
+		volumes:          volumes,
+		recorder:         recorder,
+		mounter:          mounter,
+		cache:            ccmCache,
+		reader:           apiReader,
+		liveReadFallback: opts.LiveReadFallback,
+	}
+	if opts.SnapshotFallback {
+		publisher.snapshots = newSnapshotStore(path.Join(storageDir, "snapshots"))
+	}
+	d := newDriver(host, endpoint, volumes, publisher)
+	d.mounter = mounter
+	d.apiReader = apiReader
+	d.snapshots = publisher.snapshots
+	d.name = driverName
+	d.recorder = recorder
+	d.broadcaster = broadcaster
+	if opts.Version != "" {
+		d.version = opts.Version
+	}
+	d.gcInterval = opts.GCInterval
+	d.gcConcurrency = opts.GCConcurrency
+	d.watcher = newConfigMapWatcher(ccmCache, d.refreshVolumes)
+	d.status = newStatusReporter(ccmClient, mounter, volumes, host)
+	return d, nil
 }
 
 func (d *driver) Run() error {
 	logger.Info("grpc server starting...")
 
-	u, err := url.Parse(d.endpoint)
+	addr, err := socketPath(d.endpoint)
 	if err != nil {
-		return fmt.Errorf("unable to parse address %q: %w", d.endpoint, err)
-	}
-
-	addr := path.Join(u.Host, filepath.FromSlash(u.Path))
-	if u.Host == "" {
-		addr = filepath.FromSlash(u.Path)
-	}
-
-	// CSI plugins talk only over UNIX sockets currently
-	if u.Scheme != "unix" {
-		return fmt.Errorf("currently only unix domain sockets are supported, have: %s", u.Scheme)
+		return err
 	}
 	// remove the socket if it's already there. This can happen if we
 	// deploy a new version and the socket was created from the old running
@@ -86,30 +228,153 @@ func (d *driver) Run() error {
 		return fmt.Errorf("failed to remove unix domain socket file %s: %w", addr, err)
 	}
 
-	listener, err := net.Listen(u.Scheme, addr)
+	listener, err := net.Listen("unix", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen: %w", err)
 	}
 
-	errHandler := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		resp, err := handler(ctx, req)
-		if err != nil {
-			ctrl.Log.WithName("grpc").Error(err, "method failed: "+info.FullMethod)
-		}
-		return resp, err
+	// the server and context are created before the startup garbage collection, so a stop during it is not missed
+	d.runLock.Lock()
+	if d.stopped {
+		d.runLock.Unlock()
+		_ = listener.Close()
+		logger.Info("driver stopped before the grpc server started")
+		return nil
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	d.srv = d.newServer()
+	srv := d.srv
+	d.runLock.Unlock()
 
-	doCleanup()
-
-	d.srv = grpc.NewServer(grpc.UnaryInterceptor(errHandler))
-	csi.RegisterIdentityServer(d.srv, d)
-	csi.RegisterNodeServer(d.srv, d)
+	gc := d.garbageCollector()
+	gc.Collect(ctx)
+	if d.gcInterval > 0 {
+		go gc.Start(ctx, d.gcInterval)
+	}
+	if d.watcher != nil {
+		go func() {
+			if err := d.watcher.Start(ctx); err != nil {
+				logger.Error(err, "cluster config map watcher stopped")
+			}
+		}()
+	}
+	if d.status != nil {
+		go d.status.Start(ctx)
+	}
 
 	logger.Info("grpc server started")
-	return d.srv.Serve(listener)
+	// serving a server which was already stopped fails with ErrServerStopped, like a stop during garbage collection
+	if err := srv.Serve(listener); !errors.Is(err, grpc.ErrServerStopped) {
+		return err
+	}
+	return nil
+}
+
+// socketPath returns the path of the unix socket of the csi endpoint.
+func socketPath(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse address %q: %w", endpoint, err)
+	}
+
+	addr := path.Join(u.Host, filepath.FromSlash(u.Path))
+	if u.Host == "" {
+		addr = filepath.FromSlash(u.Path)
+	}
+
+	// CSI plugins talk only over UNIX sockets currently
+	if u.Scheme != "unix" {
+		return "", fmt.Errorf("currently only unix domain sockets are supported, have: %s", u.Scheme)
+	}
+	return addr, nil
+}
+
+// newServer creates the grpc server serving the csi identity and node services.
+func (d *driver) newServer() *grpc.Server {
+	// the recovery handler runs last so the error handler logs recovered panics
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(errHandler, recoveryHandler))
+	csi.RegisterIdentityServer(srv, d)
+	csi.RegisterNodeServer(srv, d)
+	return srv
 }
 
-func (d *driver) Stop() {
+func errHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		ctrl.Log.WithName("grpc").Error(err, "method failed: "+info.FullMethod)
+	}
+	return resp, err
+}
+
+// recoveryHandler converts panics in a grpc method into an Internal error, so a single bad request can not take down
+// the node plugin.
+func recoveryHandler(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ctrl.Log.WithName("grpc").Error(fmt.Errorf("%v", r), "method panicked: "+info.FullMethod, "stack", string(debug.Stack()))
+			grpcPanic.WithLabelValues(info.FullMethod).Inc()
+			resp, err = nil, status.Error(codes.Internal, fmt.Sprintf("%s panicked: %v", info.FullMethod, r))
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// reportStatus schedules a status update for the named cluster config map, if status reporting is enabled.
+func (d *driver) reportStatus(name string) {
+	if d.status != nil {
+		d.status.Enqueue(name)
+	}
+}
+
+// Stop gracefully stops the driver. In-flight requests are drained, background refreshes are stopped, and volumes held
+// by requests or refreshes are waited on so they are not left half populated. Requests still running when the timeout
+// expires are aborted. A driver stopped before it started serving, like during the startup garbage collection, never
+// serves.
+func (d *driver) Stop(timeout time.Duration) {
 	logger.Info("server shutting down...")
-	d.srv.Stop()
+	deadline := time.Now().Add(timeout)
+	d.runLock.Lock()
+	d.stopped = true
+	srv, cancel := d.srv, d.cancel
+	d.runLock.Unlock()
+	if srv != nil {
+		stopped := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Until(deadline)):
+			logger.Info("timed out draining in-flight requests, aborting them")
+			srv.Stop()
+		}
+	}
+	if cancel != nil {
+		cancel()
+	}
+	if !d.waitForVolumes(deadline) {
+		logger.Info("timed out waiting for busy volumes")
+	}
+	if d.broadcaster != nil {
+		d.broadcaster.Shutdown()
+	}
+	logger.Info("server stopped")
+}
+
+// waitForVolumes waits until no volume is held by a request or refresh, returning false if the deadline expires first.
+func (d *driver) waitForVolumes(deadline time.Time) bool {
+	for {
+		d.volumeLock.Lock()
+		busy := len(d.volumeBusy)
+		d.volumeLock.Unlock()
+		if busy == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
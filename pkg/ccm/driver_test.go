@@ -0,0 +1,75 @@
+package ccm
+
+import (
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/mount-utils"
+)
+
+func Test_driver_Stop(t *testing.T) {
+	type testcase struct {
+		description string
+		holdVolume  time.Duration
+		timeout     time.Duration
+		released    bool
+	}
+	tests := []testcase{
+		{
+			description: "stop should return immediately without busy volumes",
+			timeout:     time.Second,
+			released:    true,
+		},
+		{
+			description: "stop should wait for busy volumes to be released",
+			holdVolume:  200 * time.Millisecond,
+			timeout:     5 * time.Second,
+			released:    true,
+		},
+		{
+			description: "stop should give up on busy volumes after the timeout",
+			holdVolume:  5 * time.Second,
+			timeout:     200 * time.Millisecond,
+			released:    false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			driver := newDriver("test", "", newTestVolumeStore(t), &mockVolumePublisher{})
+			driver.srv = driver.newServer()
+			if test.holdVolume > 0 {
+				require.True(t, driver.tryLockVolume("test-volume-id"))
+				time.AfterFunc(test.holdVolume, func() {
+					driver.unlockVolume("test-volume-id")
+				})
+			}
+
+			driver.Stop(test.timeout)
+			driver.volumeLock.Lock()
+			defer driver.volumeLock.Unlock()
+			require.Equal(t, test.released, len(driver.volumeBusy) == 0)
+		})
+	}
+}
+
+func Test_driver_Run_Stopped(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	driver := newDriver("test", "unix://"+path.Join(t.TempDir(), "csi.sock"), volumes, &mockVolumePublisher{})
+	driver.mounter = mount.NewFakeMounter(nil)
+	driver.Stop(time.Second)
+
+	ran := make(chan error, 1)
+	go func() {
+		ran <- driver.Run()
+	}()
+	select {
+	case err := <-ran:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a driver stopped before it started should not serve")
+	}
+}
@@ -0,0 +1,92 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// event reasons posted on pods and cluster config maps.
+const (
+	reasonNotFound         = "ClusterConfigMapNotFound"
+	reasonNamespaceDenied  = "NamespaceNotAllowed"
+	reasonInvalid          = "InvalidClusterConfigMap"
+	reasonPopulateFailed   = "FailedPopulate"
+	reasonSnapshotFallback = "SnapshotFallback"
+	reasonRefreshed        = "Refreshed"
+	reasonRefreshFailed    = "FailedRefresh"
+)
+
+// event posts an event on the referenced object, if events are enabled and the object is known.
+func (d *driver) event(ref *corev1.ObjectReference, eventType, reason, messageFmt string, args ...interface{}) {
+	if d.recorder == nil || ref == nil {
+		return
+	}
+	d.recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+// podRef references the pod a volume was published for, or nil when kubelet did not pass the pod info on mount.
+func podRef(pod PodMeta) *corev1.ObjectReference {
+	if pod.Name == "" || pod.Namespace == "" {
+		return nil
+	}
+	return &corev1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Pod",
+		Namespace:  pod.Namespace,
+		Name:       pod.Name,
+		UID:        types.UID(pod.UID),
+	}
+}
+
+// clusterConfigMapRef references the named cluster config map, including its uid when it is in the cache so the events
+// are listed by kubectl describe.
+func (d *driver) clusterConfigMapRef(ctx context.Context, name string) *corev1.ObjectReference {
+	ref := &corev1.ObjectReference{
+		APIVersion: v1alpha1.SchemeGroupVersion.String(),
+		Kind:       "ClusterConfigMap",
+		Name:       name,
+	}
+	if d.watcher != nil {
+		ccm := &v1alpha1.ClusterConfigMap{}
+		if err := d.watcher.cache.Get(ctx, client.ObjectKey{Name: name}, ccm); err == nil {
+			ref.UID = ccm.UID
+			ref.ResourceVersion = ccm.ResourceVersion
+		}
+	}
+	return ref
+}
+
+// populateFailed posts the failure to populate a volume on the pod and the cluster config map. Cluster config maps
+// which do not exist are only reported on the pod.
+func (d *driver) populateFailed(ctx context.Context, meta *ClusterConfigMapMeta, err error) {
+	if apierrors.IsNotFound(err) {
+		d.event(podRef(meta.Pod), corev1.EventTypeWarning, reasonNotFound, "Cluster config map %q of volume %q not found: %s", meta.Name, meta.VolumeID, err.Error())
+		return
+	}
+
+	reason := reasonPopulateFailed
+	switch {
+	case errors.Is(err, errPermissionDenied):
+		reason = reasonNamespaceDenied
+	case errors.Is(err, errInvalidKey), errors.Is(err, errMissingKey):
+		reason = reasonInvalid
+	}
+	d.event(podRef(meta.Pod), corev1.EventTypeWarning, reason, "Failed to populate volume %q from cluster config map %q: %s", meta.VolumeID, meta.Name, err.Error())
+	d.event(d.clusterConfigMapRef(ctx, meta.Name), corev1.EventTypeWarning, reason, "Failed to populate volume %q of pod %s: %s", meta.VolumeID, podName(meta.Pod), err.Error())
+}
+
+// podName returns the namespaced name of the pod, or unknown when kubelet did not pass the pod info on mount.
+func podName(pod PodMeta) string {
+	if pod.Name == "" {
+		return "unknown"
+	}
+	return pod.Namespace + "/" + pod.Name
+}
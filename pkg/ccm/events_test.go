@@ -0,0 +1,133 @@
+package ccm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
+)
+
+// drainEvents returns the events posted to the fake recorder so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func Test_NodePublishVolume_Events(t *testing.T) {
+	notFound := apierrors.NewNotFound(v1alpha1.SchemeGroupVersion.WithResource("clusterconfigmaps").GroupResource(), "test-cluster-config-maps")
+
+	type testcase struct {
+		description string
+		populateErr error
+		podInfo     bool
+		events      []string
+	}
+	tests := []testcase{
+		{
+			description: "a successful publish should not post events",
+			podInfo:     true,
+		},
+		{
+			description: "a missing cluster config map should only be posted on the pod",
+			populateErr: fmt.Errorf("failed to read cluster configmap: %w", notFound),
+			podInfo:     true,
+			events:      []string{"Warning ClusterConfigMapNotFound", "kind=Pod"},
+		},
+		{
+			description: "a denied namespace should be posted on the pod and the cluster config map",
+			populateErr: fmt.Errorf("%w: namespace %q is not allowed", errPermissionDenied, "test-namespace"),
+			podInfo:     true,
+			events: []string{
+				"Warning NamespaceNotAllowed", "kind=Pod",
+				"Warning NamespaceNotAllowed", "kind=ClusterConfigMap",
+			},
+		},
+		{
+			description: "populate failures should only be posted on the cluster config map without pod info",
+			populateErr: fmt.Errorf("%w: path %q must be relative", errInvalidKey, "/etc/passwd"),
+			podInfo:     false,
+			events:      []string{"Warning InvalidClusterConfigMap", "kind=ClusterConfigMap"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			mockPublisher := &mockVolumePublisher{}
+			mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(test.populateErr)
+			mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil).Maybe()
+			driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+			recorder := record.NewFakeRecorder(10)
+			recorder.IncludeObject = true
+			driver.recorder = recorder
+
+			volumeContext := map[string]string{"name": "test-cluster-config-maps"}
+			if test.podInfo {
+				volumeContext[podNameKey] = "test-pod"
+				volumeContext[podNamespaceKey] = "test-namespace"
+			}
+			_, _ = driver.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume-id",
+				TargetPath: "/tmp/test-path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeContext: volumeContext,
+			})
+
+			events := drainEvents(recorder)
+			require.Len(t, events, len(test.events)/2, events)
+			for i, event := range events {
+				require.Contains(t, event, test.events[2*i])
+				require.Contains(t, event, test.events[2*i+1])
+			}
+		})
+	}
+}
+
+func Test_refreshVolumes_Events(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	meta := &ClusterConfigMapMeta{
+		Name:       "test-cluster-config-maps",
+		VolumeID:   "test-volume-id",
+		Generation: 1,
+		Pod:        PodMeta{Name: "test-pod", Namespace: "test-namespace"},
+	}
+	require.NoError(t, volumes.WriteMetadata(meta))
+	_, err := volumes.DataDir(meta.VolumeID)
+	require.NoError(t, err)
+
+	mockPublisher := &mockVolumePublisher{}
+	mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
+		meta.Generation = 2
+		return nil
+	})
+	driver := newDriver("test", "", volumes, mockPublisher)
+	recorder := record.NewFakeRecorder(10)
+	recorder.IncludeObject = true
+	driver.recorder = recorder
+
+	require.NoError(t, driver.refreshVolumes(context.TODO(), "test-cluster-config-maps", "", 2))
+	events := drainEvents(recorder)
+	require.Len(t, events, 2, events)
+	require.Contains(t, events[0], "Normal Refreshed")
+	require.Contains(t, events[0], "kind=Pod")
+	require.Contains(t, events[1], "Normal Refreshed")
+	require.Contains(t, events[1], "kind=ClusterConfigMap")
+}
@@ -0,0 +1,123 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+// healthCheckTimeout bounds each health check, so a hanging apiserver does not block probes.
+const healthCheckTimeout = 5 * time.Second
+
+// healthCheck fails when a dependency of the driver is unhealthy.
+type healthCheck func(ctx context.Context) error
+
+// livenessChecks fail when the driver can not serve volumes, and restarting it may recover.
+func (d *driver) livenessChecks() map[string]healthCheck {
+	return map[string]healthCheck{
+		"socket":  d.checkSocket,
+		"storage": d.checkStorage,
+	}
+}
+
+// readinessChecks fail when the driver can not serve volumes, including when cluster config maps can not be read.
+// Restarting the driver does not help while the apiserver is unreachable, so these are not part of the liveness checks.
+func (d *driver) readinessChecks() map[string]healthCheck {
+	checks := d.livenessChecks()
+	checks["informer"] = d.checkInformer
+	checks["apiserver"] = d.checkAPIServer
+	return checks
+}
+
+// LivenessChecks returns the liveness checks of the driver, to be served on /healthz.
+func (d *driver) LivenessChecks() map[string]healthz.Checker {
+	return checkers(d.livenessChecks())
+}
+
+// ReadinessChecks returns the readiness checks of the driver, to be served on /readyz.
+func (d *driver) ReadinessChecks() map[string]healthz.Checker {
+	return checkers(d.readinessChecks())
+}
+
+func checkers(checks map[string]healthCheck) map[string]healthz.Checker {
+	checkers := make(map[string]healthz.Checker, len(checks))
+	for name, check := range checks {
+		checkers[name] = func(req *http.Request) error {
+			ctx, cancel := context.WithTimeout(req.Context(), healthCheckTimeout)
+			defer cancel()
+			return check(ctx)
+		}
+	}
+	return checkers
+}
+
+// ready runs every readiness check, returning the failures.
+func (d *driver) ready(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	var errs []error
+	for name, check := range d.readinessChecks() {
+		if err := check(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s check failed: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkSocket fails when the csi socket is not accepting connections.
+func (d *driver) checkSocket(ctx context.Context) error {
+	addr, err := socketPath(d.endpoint)
+	if err != nil {
+		return err
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to csi socket %q: %w", addr, err)
+	}
+	return conn.Close()
+}
+
+// checkStorage fails when files can not be written to the storage directory.
+func (d *driver) checkStorage(context.Context) error {
+	file, err := os.CreateTemp(d.volumes.dir, ".probe-")
+	if err != nil {
+		return fmt.Errorf("storage dir %q is not writable: %w", d.volumes.dir, err)
+	}
+	_ = file.Close()
+	return os.Remove(file.Name())
+}
+
+// checkInformer fails until the cluster config map informer has synced.
+func (d *driver) checkInformer(ctx context.Context) error {
+	if d.watcher == nil {
+		return nil
+	}
+	if !d.watcher.cache.WaitForCacheSync(ctx) {
+		return errors.New("cluster config map informer has not synced")
+	}
+	return nil
+}
+
+// checkAPIServer fails when cluster config maps can not be listed from the apiserver.
+func (d *driver) checkAPIServer(ctx context.Context) error {
+	if d.apiReader == nil {
+		return nil
+	}
+	list := &metav1.PartialObjectMetadataList{}
+	list.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("ClusterConfigMapList"))
+	if err := d.apiReader.List(ctx, list, client.Limit(1)); err != nil {
+		return fmt.Errorf("failed to list cluster config maps: %w", err)
+	}
+	return nil
+}
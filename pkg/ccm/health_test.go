@@ -0,0 +1,100 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+func Test_driver_ready(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	type testcase struct {
+		description  string
+		listening    bool
+		storageDir   func(t *testing.T) string
+		apiserverErr error
+		ready        bool
+		healthy      bool
+	}
+	tests := []testcase{
+		{
+			description: "the driver should be ready when every check passes",
+			listening:   true,
+			storageDir:  func(t *testing.T) string { return t.TempDir() },
+			ready:       true,
+			healthy:     true,
+		},
+		{
+			description: "the driver should not be ready or healthy when the csi socket is not listening",
+			listening:   false,
+			storageDir:  func(t *testing.T) string { return t.TempDir() },
+			ready:       false,
+			healthy:     false,
+		},
+		{
+			description: "the driver should not be ready or healthy when the storage dir is not writable",
+			listening:   true,
+			storageDir:  func(t *testing.T) string { return path.Join(t.TempDir(), "missing") },
+			ready:       false,
+			healthy:     false,
+		},
+		{
+			description:  "the driver should be healthy but not ready when the apiserver is unreachable",
+			listening:    true,
+			storageDir:   func(t *testing.T) string { return t.TempDir() },
+			apiserverErr: errors.New("connection refused"),
+			ready:        false,
+			healthy:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			volumes := newVolumeStore(test.storageDir(t))
+
+			socket := path.Join(t.TempDir(), "csi.sock")
+			if test.listening {
+				listener, err := net.Listen("unix", socket)
+				require.NoError(t, err)
+				t.Cleanup(func() {
+					_ = listener.Close()
+				})
+			}
+
+			driver := newDriver("test", "unix://"+socket, volumes, &mockVolumePublisher{})
+			driver.apiReader = fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+				List: func(ctx context.Context, c client.WithWatch, list client.ObjectList, opts ...client.ListOption) error {
+					if test.apiserverErr != nil {
+						return test.apiserverErr
+					}
+					return c.List(ctx, list, opts...)
+				},
+			}).Build()
+
+			err := driver.ready(context.TODO())
+			require.Equal(t, test.ready, err == nil, err)
+
+			recorder := httptest.NewRecorder()
+			handler := http.StripPrefix("/healthz", &healthz.Handler{Checks: driver.LivenessChecks()})
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			require.Equal(t, test.healthy, recorder.Code == http.StatusOK, recorder.Body.String())
+		})
+	}
+}
@@ -4,32 +4,31 @@ import (
 	"context"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
 )
 
 var _ csi.IdentityServer = (*driver)(nil)
 
 func (d *driver) GetPluginInfo(ctx context.Context, req *csi.GetPluginInfoRequest) (*csi.GetPluginInfoResponse, error) {
 	return &csi.GetPluginInfoResponse{
-		Name:          "clusterconfigmaps.indeed.com",
-		VendorVersion: "0.0.1",
+		Name:          d.name,
+		VendorVersion: d.version,
 	}, nil
 }
 
 func (d *driver) GetPluginCapabilities(ctx context.Context, req *csi.GetPluginCapabilitiesRequest) (*csi.GetPluginCapabilitiesResponse, error) {
 	logger.V(2).Info("get plugin capabilities called")
-	return &csi.GetPluginCapabilitiesResponse{
-		Capabilities: []*csi.PluginCapability{
-			{
-				Type: &csi.PluginCapability_Service_{
-					Service: &csi.PluginCapability_Service{
-						Type: csi.PluginCapability_Service_UNKNOWN,
-					},
-				},
-			},
-		},
-	}, nil
+	// The driver only runs a node service for inline volumes, so it has no plugin capabilities to advertise.
+	return &csi.GetPluginCapabilitiesResponse{}, nil
 }
 
+// Probe reports the driver as not ready when the storage dir is not writable, the csi socket is not listening, or
+// cluster config maps can not be read.
 func (d *driver) Probe(ctx context.Context, req *csi.ProbeRequest) (*csi.ProbeResponse, error) {
-	return &csi.ProbeResponse{}, nil
+	if err := d.ready(ctx); err != nil {
+		logger.Info("probe failed: " + err.Error())
+		return &csi.ProbeResponse{Ready: wrapperspb.Bool(false)}, nil
+	}
+	return &csi.ProbeResponse{Ready: wrapperspb.Bool(true)}, nil
 }
@@ -0,0 +1,69 @@
+package ccm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// KeyToPath projects a cluster config map key to a relative path within the volume, the same as the items of a
+// native ConfigMap volume source.
+type KeyToPath struct {
+	// Key is the cluster config map key to project.
+	Key string `json:"key"`
+	// Path is the relative path of the file to map the key to, which may contain nested directories.
+	Path string `json:"path"`
+	// Mode is the octal unix permissions of the file, defaulting to the mode of the volume.
+	Mode string `json:"mode,omitempty"`
+}
+
+// parseItems parses the items volume attribute, which is either a json list of KeyToPath, or the compact
+// `key=path,key2=dir/path` form. An empty attribute selects every key of the cluster config map.
+func parseItems(attr string) ([]KeyToPath, error) {
+	attr = strings.TrimSpace(attr)
+	if attr == "" {
+		return nil, nil
+	}
+
+	var items []KeyToPath
+	if strings.HasPrefix(attr, "[") {
+		if err := json.Unmarshal([]byte(attr), &items); err != nil {
+			return nil, fmt.Errorf("failed to parse items %q as json: %w", attr, err)
+		}
+	} else {
+		for _, item := range strings.Split(attr, ",") {
+			key, itemPath, ok := strings.Cut(strings.TrimSpace(item), "=")
+			if !ok {
+				return nil, fmt.Errorf("item %q must be of the form key=path", item)
+			}
+			items = append(items, KeyToPath{Key: strings.TrimSpace(key), Path: strings.TrimSpace(itemPath)})
+		}
+	}
+
+	paths := make(map[string]bool, len(items))
+	for _, item := range items {
+		if item.Key == "" {
+			return nil, fmt.Errorf("item for path %q must set a key", item.Path)
+		}
+		if err := validatePath(item.Path); err != nil {
+			return nil, fmt.Errorf("invalid path for item %q: %w", item.Key, err)
+		}
+		if _, err := parseMode(item.Mode); err != nil {
+			return nil, fmt.Errorf("invalid mode for item %q: %w", item.Key, err)
+		}
+		if paths[item.Path] {
+			return nil, fmt.Errorf("path %q is used by more than one item", item.Path)
+		}
+		paths[item.Path] = true
+	}
+	// a path can not be both a file and the parent dir of another file
+	for itemPath := range paths {
+		for dir := itemPath; strings.Contains(dir, "/"); {
+			dir = dir[:strings.LastIndex(dir, "/")]
+			if paths[dir] {
+				return nil, fmt.Errorf("path %q conflicts with the parent dir of path %q", dir, itemPath)
+			}
+		}
+	}
+	return items, nil
+}
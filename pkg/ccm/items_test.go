@@ -0,0 +1,173 @@
+package ccm
+
+import (
+	"os"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseItems(t *testing.T) {
+	type testcase struct {
+		description string
+		attr        string
+		items       []KeyToPath
+		err         string
+	}
+	tests := []testcase{
+		{
+			description: "an empty attribute should select every key",
+			attr:        "",
+		},
+		{
+			description: "the compact form should be parsed",
+			attr:        "foo=foo.txt, bar=conf/bar.properties",
+			items: []KeyToPath{
+				{Key: "foo", Path: "foo.txt"},
+				{Key: "bar", Path: "conf/bar.properties"},
+			},
+		},
+		{
+			description: "the json form should be parsed",
+			attr:        `[{"key":"foo","path":"foo.txt"},{"key":"run","path":"bin/run.sh","mode":"0755"}]`,
+			items: []KeyToPath{
+				{Key: "foo", Path: "foo.txt"},
+				{Key: "run", Path: "bin/run.sh", Mode: "0755"},
+			},
+		},
+		{
+			description: "items without a path should be rejected",
+			attr:        "foo",
+			err:         "must be of the form key=path",
+		},
+		{
+			description: "paths escaping the volume should be rejected",
+			attr:        "foo=../foo.txt",
+			err:         "must not contain '..'",
+		},
+		{
+			description: "absolute paths should be rejected",
+			attr:        `[{"key":"foo","path":"/etc/foo.txt"}]`,
+			err:         "must be relative",
+		},
+		{
+			description: "paths with '.' elements should be rejected",
+			attr:        "foo=./foo.txt",
+			err:         "must not contain '.' or empty elements",
+		},
+		{
+			description: "paths with empty elements should be rejected",
+			attr:        "foo=conf//foo.txt",
+			err:         "must not contain '.' or empty elements",
+		},
+		{
+			description: "paths with a trailing slash should be rejected",
+			attr:        `[{"key":"foo","path":"conf/"}]`,
+			err:         "must not contain '.' or empty elements",
+		},
+		{
+			description: "invalid modes should be rejected",
+			attr:        `[{"key":"foo","path":"foo.txt","mode":"rwx"}]`,
+			err:         "invalid mode",
+		},
+		{
+			description: "duplicate paths should be rejected",
+			attr:        "foo=foo.txt,bar=foo.txt",
+			err:         "used by more than one item",
+		},
+		{
+			description: "paths which are the parent dir of other paths should be rejected",
+			attr:        "foo=conf,bar=conf/bar.txt",
+			err:         "conflicts with the parent dir",
+		},
+	}
+
+	for _, test := range tests {
+		items, err := parseItems(test.attr)
+		if test.err != "" {
+			require.Error(t, err, test.description)
+			require.Contains(t, err.Error(), test.err, test.description)
+			continue
+		}
+		require.NoError(t, err, test.description)
+		require.Equal(t, test.items, items, test.description)
+	}
+}
+
+func Test_projectContents(t *testing.T) {
+	contents := map[string][]byte{
+		"foo": []byte("foo"),
+		"run": []byte("#!/bin/sh"),
+	}
+
+	payload, err := projectContents(&ClusterConfigMapMeta{Name: "test-ccm"}, contents, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]fileProjection{
+		"foo": {Data: []byte("foo"), Mode: defaultMode},
+		"run": {Data: []byte("#!/bin/sh"), Mode: defaultMode},
+	}, payload, "every key should be projected without items")
+
+	payload, err = projectContents(&ClusterConfigMapMeta{
+		Name:  "test-ccm",
+		Mode:  "0600",
+		Items: []KeyToPath{{Key: "run", Path: "bin/run.sh", Mode: "0755"}, {Key: "foo", Path: "conf/foo"}},
+	}, contents, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]fileProjection{
+		"bin/run.sh": {Data: []byte("#!/bin/sh"), Mode: os.FileMode(0755)},
+		"conf/foo":   {Data: []byte("foo"), Mode: os.FileMode(0600)},
+	}, payload, "only the selected items should be projected")
+
+	_, err = projectContents(&ClusterConfigMapMeta{
+		Name:  "test-ccm",
+		Items: []KeyToPath{{Key: "missing", Path: "missing"}},
+	}, contents, nil)
+	require.ErrorIs(t, err, errMissingKey)
+}
+
+func Test_projectContents_FileAttributes(t *testing.T) {
+	contents := map[string][]byte{
+		"foo": []byte("foo"),
+		"run": []byte("#!/bin/sh"),
+	}
+	uid, gid := int64(1000), int64(2000)
+	attributes := map[string]v1alpha1.FileAttributes{
+		"run": {Mode: "0750", UID: &uid, GID: &gid},
+	}
+
+	payload, err := projectContents(&ClusterConfigMapMeta{Name: "test-ccm", Mode: "0600"}, contents, attributes)
+	require.NoError(t, err)
+	require.Equal(t, map[string]fileProjection{
+		"foo": {Data: []byte("foo"), Mode: os.FileMode(0600)},
+		"run": {Data: []byte("#!/bin/sh"), Mode: os.FileMode(0750), UID: &uid, GID: &gid},
+	}, payload, "file attributes should take precedence over the volume mode")
+
+	payload, err = projectContents(&ClusterConfigMapMeta{
+		Name:  "test-ccm",
+		Items: []KeyToPath{{Key: "run", Path: "run.sh", Mode: "0700"}},
+	}, contents, attributes)
+	require.NoError(t, err)
+	require.Equal(t, map[string]fileProjection{
+		"run.sh": {Data: []byte("#!/bin/sh"), Mode: os.FileMode(0700), UID: &uid, GID: &gid},
+	}, payload, "item modes should take precedence over file attributes")
+}
+
+func Test_projectContents_FSGroup(t *testing.T) {
+	contents := map[string][]byte{
+		"foo": []byte("foo"),
+		"run": []byte("#!/bin/sh"),
+	}
+	uid, fsGroup, gid := int64(1000), int64(3000), int64(2000)
+	attributes := map[string]v1alpha1.FileAttributes{
+		"run": {GID: &gid},
+	}
+
+	payload, err := projectContents(&ClusterConfigMapMeta{Name: "test-ccm", Mode: "0600", UID: &uid, FSGroup: &fsGroup}, contents, attributes)
+	require.NoError(t, err)
+	require.Equal(t, map[string]fileProjection{
+		"foo": {Data: []byte("foo"), Mode: os.FileMode(0640), UID: &uid, GID: &fsGroup},
+		"run": {Data: []byte("#!/bin/sh"), Mode: os.FileMode(0600), UID: &uid, GID: &gid},
+	}, payload, "files owned by the fs group should be group readable")
+}
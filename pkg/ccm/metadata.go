@@ -7,6 +7,8 @@ import (
 	"path"
 	"strconv"
 	"time"
+
+	"k8s.io/apimachinery/pkg/types"
 )
 
 // ClusterConfigMapMeta contains metadata recorded when a cluster config map csi volume request occurs. This is useful
@@ -14,56 +16,113 @@ import (
 // subsequent grpc requests, like the volume unpublish request, lack much of the metadata present in the publish request.
 // Recording the original request details allows future requests to have a more complete view of the cluster config map volume.
 type ClusterConfigMapMeta struct {
-	Name       string        `json:"name"`
-	Created    time.Time     `json:"created"`
-	Mode       string        `json:"mode"`
-	VolumeID   string        `json:"volumeId"`
-	TargetPath string        `json:"targetPath"`
-	FSType     string        `json:"fsType"`
-	BindOpts   []string      `json:"bindOpts"`
-	Directory  DirectoryMeta `json:"directory"`
-}
-
-// dir is a helper func which ensures the directory name for the volume id exists under the ccm data dir, or creates it if it does not.
-func dir(name, volumeID string) (string, error) {
-	dir := path.Join(storageDir, name, volumeID)
-	err := os.MkdirAll(dir, 0755)
+	Name                string        `json:"name"`
+	Created             time.Time     `json:"created"`
+	Synced              time.Time     `json:"synced"`
+	ClusterConfigMapUID types.UID     `json:"clusterConfigMapUid,omitempty"`
+	ResourceVersion     string        `json:"resourceVersion"`
+	Generation          int64         `json:"generation"`
+	Hash                string        `json:"hash"`
+	Mode                string        `json:"mode"`
+	Items               []KeyToPath   `json:"items,omitempty"`
+	UID                 *int64        `json:"uid,omitempty"`
+	GID                 *int64        `json:"gid,omitempty"`
+	FSGroup             *int64        `json:"fsGroup,omitempty"`
+	Pod                 PodMeta       `json:"pod"`
+	VolumeID            string        `json:"volumeId"`
+	TargetPath          string        `json:"targetPath"`
+	FSType              string        `json:"fsType"`
+	BindOpts            []string      `json:"bindOpts"`
+	Directory           DirectoryMeta `json:"directory"`
+}
+
+// SyncedTo returns whether the volume was populated from the given generation of the cluster config map. Generations
+// restart when a cluster config map is deleted and created again, so the uid of the cluster config map must match too.
+func (c *ClusterConfigMapMeta) SyncedTo(uid types.UID, generation int64) bool {
+	return c.ClusterConfigMapUID == uid && c.Generation == generation
+}
+
+// FileMode returns the unix permissions for files created for the cluster config map, or the default permissions if unset.
+func (c *ClusterConfigMapMeta) FileMode() (os.FileMode, error) {
+	mode, err := parseMode(c.Mode)
 	if err != nil {
-		return "", fmt.Errorf("failed to create data dir %q: %w", dir, err)
+		return defaultMode, fmt.Errorf("failed to parse mode %q for ccm %q: %w", c.Mode, c.Name, err)
 	}
-	return dir, nil
+	return mode, nil
 }
 
-// DataDir ensures the directory for the cluster config map csi data exists and returns the path.
-func (c *ClusterConfigMapMeta) DataDir() (string, error) {
-	return dir("data", c.VolumeID)
+// Owner returns the user and group ids owning the volume directories, defaulting the group to the fs group of the pod.
+func (c *ClusterConfigMapMeta) Owner() (uid, gid *int64) {
+	if c.GID == nil {
+		return c.UID, c.FSGroup
+	}
+	return c.UID, c.GID
 }
 
-// MetadataDir ensures the directory for the cluster config map metadata exists and returns the path.
-func (c *ClusterConfigMapMeta) MetadataDir() (string, error) {
-	return dir("metadata", c.VolumeID)
+// parseID parses a user or group id, returning nil if unset.
+func parseID(id string) (*int64, error) {
+	if id == "" {
+		return nil, nil
+	}
+	parsedID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if parsedID < 0 {
+		return nil, fmt.Errorf("id %d must not be negative", parsedID)
+	}
+	return &parsedID, nil
 }
 
-// FileMode returns the unix permissions for files created for the cluster config map, or the default permissions if unset.
-func (c *ClusterConfigMapMeta) FileMode() (os.FileMode, error) {
-	if c.Mode == "" {
+// parseMode parses octal unix permissions, returning the default permissions if unset.
+func parseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
 		return defaultMode, nil
 	}
-	if parsedMode, err := strconv.ParseUint(c.Mode, 8, 32); err == nil {
-		return os.FileMode(parsedMode), nil
-	} else {
-		return defaultMode, fmt.Errorf("failed to parse mode %q for ccm %q: %w", c.Mode, c.Name, err)
+	parsedMode, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return defaultMode, err
+	}
+	return os.FileMode(parsedMode), nil
+}
+
+// volumeStore holds the data and metadata of every volume published on the node, in the data and metadata dirs of
+// its storage dir.
+type volumeStore struct {
+	dir string
+}
+
+func newVolumeStore(dir string) *volumeStore {
+	return &volumeStore{dir: dir}
+}
+
+// DataPath returns the path of the data dir of the volume, without creating it.
+func (s *volumeStore) DataPath(volumeID string) string {
+	return path.Join(s.dir, "data", volumeID)
+}
+
+// MetadataPath returns the path of the metadata dir of the volume, without creating it.
+func (s *volumeStore) MetadataPath(volumeID string) string {
+	return path.Join(s.dir, "metadata", volumeID)
+}
+
+// DataDir ensures the data dir of the volume exists and returns its path.
+func (s *volumeStore) DataDir(volumeID string) (string, error) {
+	dir := s.DataPath(volumeID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create data dir %q: %w", dir, err)
 	}
+	return dir, nil
 }
 
 // WriteMetadata marshals and persists the json metadata of cluster config map to the filesystem.
-func (c *ClusterConfigMapMeta) WriteMetadata() error {
-	dir, err := c.MetadataDir()
-	if err != nil {
-		return fmt.Errorf("failed to get metadata dir: %w", err)
+func (s *volumeStore) WriteMetadata(meta *ClusterConfigMapMeta) error {
+	dir := s.MetadataPath(meta.VolumeID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create metadata dir %q: %w", dir, err)
 	}
 
-	bytes, err := json.Marshal(c)
+	bytes, err := json.Marshal(meta)
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
@@ -72,13 +131,8 @@ func (c *ClusterConfigMapMeta) WriteMetadata() error {
 }
 
 // ReadMetadata unmarshalls and parses the json metadata of cluster config map from the filesystem.
-func ReadMetadata(volumeID string) (*ClusterConfigMapMeta, error) {
-	dir, err := dir("metadata", volumeID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata dir: %w", err)
-	}
-
-	bytes, err := os.ReadFile(path.Join(dir, "metadata.json"))
+func (s *volumeStore) ReadMetadata(volumeID string) (*ClusterConfigMapMeta, error) {
+	bytes, err := os.ReadFile(path.Join(s.MetadataPath(volumeID), "metadata.json"))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read metadata.json for volume %q: %w", volumeID, err)
 	}
@@ -89,6 +143,41 @@ func ReadMetadata(volumeID string) (*ClusterConfigMapMeta, error) {
 	return meta, nil
 }
 
+// ListMetadata reads the metadata of every volume recorded in the metadata storage directory. Volumes with missing or
+// unreadable metadata are logged and skipped.
+func (s *volumeStore) ListMetadata() ([]*ClusterConfigMapMeta, error) {
+	metadataDir := path.Join(s.dir, "metadata")
+	dirEntries, err := os.ReadDir(metadataDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list dir entries for %q: %w", metadataDir, err)
+	}
+
+	metas := make([]*ClusterConfigMapMeta, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		meta, err := s.ReadMetadata(dirEntry.Name())
+		if err != nil {
+			logger.V(2).Info(fmt.Sprintf("skipping volume %q with unreadable metadata: %s", dirEntry.Name(), err.Error()))
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	return metas, nil
+}
+
+// PodMeta identifies the pod the volume was published for, when the csi driver is configured with podInfoOnMount.
+type PodMeta struct {
+	Name           string `json:"name,omitempty"`
+	Namespace      string `json:"namespace,omitempty"`
+	UID            string `json:"uid,omitempty"`
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+}
+
 type DirectoryMeta struct {
 	Path     string        `json:"path"`
 	Contents []ContentMeta `json:"files"`
@@ -45,6 +45,77 @@ var (
 		Help:      "node unpublish volume errors for cluster config maps",
 	}, []string{"name", "reason"})
 
+	publishCoalesced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "publish_volume_coalesced",
+		Help:      "node publish volume requests sharing the result of a concurrent request for the same target",
+	}, []string{"name"})
+	volumeLockWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "volume_lock_wait_duration",
+		Help:      "time requests spent waiting on concurrent requests for the same volume",
+	}, []string{"operation"})
+
+	volumeStatsErr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "volume_stats_error",
+		Help:      "node get volume stats errors for cluster config maps",
+	}, []string{"name", "reason"})
+
+	grpcPanic = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "grpc_panic",
+		Help:      "panics recovered in grpc methods of the node plugin",
+	}, []string{"method"})
+
+	refresh = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "refresh_volume_success",
+		Help:      "node refresh volume success for cluster config maps",
+	}, []string{"name"})
+	refreshErr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "refresh_volume_error",
+		Help:      "node refresh volume errors for cluster config maps",
+	}, []string{"name", "reason"})
+	lastSynced = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "volume_last_synced_timestamp_seconds",
+		Help:      "unix time the volume contents were last synced from the cluster config map",
+	}, []string{"name", "volume"})
+
+	cacheMiss = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "cache_miss",
+		Help:      "cluster config maps read from the apiserver because they were missing from the cache",
+	}, []string{"name"})
+	snapshotFallback = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "snapshot_fallback",
+		Help:      "volumes published from a snapshot because the cluster config map could not be read",
+	}, []string{"name"})
+	snapshotErr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "snapshot_error",
+		Help:      "failed operations on the snapshots kept for cluster config maps",
+	}, []string{"name", "reason"})
+	statusErr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "status_update_error",
+		Help:      "failed status updates of cluster config maps",
+	}, []string{"name"})
+
 	cleanupTime = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: "ccm",
 		Subsystem: "node",
@@ -57,12 +128,33 @@ var (
 		Name:      "cleanup_volume_error",
 		Help:      "failed cleanup operations for data and metadata kept for cluster config maps",
 	}, []string{"reason"})
+	gcOrphans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "gc_orphaned_volumes",
+		Help:      "data and metadata dirs of orphaned volumes removed by garbage collection",
+	}, []string{"kind"})
+	gcReclaimedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "gc_reclaimed_bytes",
+		Help:      "bytes of volume data reclaimed by garbage collection",
+	}, []string{})
+	gcSkipped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "ccm",
+		Subsystem: "node",
+		Name:      "gc_skipped_volumes",
+		Help:      "volumes skipped by garbage collection because they were busy",
+	}, []string{})
 )
 
 func init() {
 	Metrics.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	Metrics.MustRegister(collectors.NewGoCollector())
-	Metrics.MustRegister(publish, publishTime, publishErr)
+	Metrics.MustRegister(publish, publishTime, publishErr, publishCoalesced, volumeLockWait)
 	Metrics.MustRegister(unpublish, unpublishTime, unpublishErr)
-	Metrics.MustRegister(cleanupTime, cleanupErr)
+	Metrics.MustRegister(volumeStatsErr, grpcPanic)
+	Metrics.MustRegister(refresh, refreshErr, lastSynced)
+	Metrics.MustRegister(cacheMiss, snapshotFallback, snapshotErr, statusErr)
+	Metrics.MustRegister(cleanupTime, cleanupErr, gcOrphans, gcReclaimedBytes, gcSkipped)
 }
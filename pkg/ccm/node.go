@@ -2,8 +2,10 @@ package ccm
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path"
 	"strings"
 	"time"
 
@@ -11,14 +13,22 @@ import (
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
-
-	"k8s.io/mount-utils"
 )
 
 var _ csi.NodeServer = (*driver)(nil)
 
 const defaultMode = os.FileMode(0644)
-const storageDir = "/csi-ccm-data"
+
+// publishTimeout bounds the time spent publishing a volume, independent of the request which started the publish.
+const publishTimeout = 2 * time.Minute
+
+// volume context keys set by kubelet when the csi driver is configured with podInfoOnMount.
+const (
+	podNameKey           = "csi.storage.k8s.io/pod.name"
+	podNamespaceKey      = "csi.storage.k8s.io/pod.namespace"
+	podUIDKey            = "csi.storage.k8s.io/pod.uid"
+	podServiceAccountKey = "csi.storage.k8s.io/serviceAccount.name"
+)
 
 func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
 	start := time.Now()
@@ -37,6 +47,10 @@ func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		publishErr.WithLabelValues(configMap, "missing volume id").Inc()
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume volume id must be provided")
 	}
+	if err := validateVolumeID(req.VolumeId); err != nil {
+		publishErr.WithLabelValues(configMap, "invalid volume id").Inc()
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("NodePublishVolume %s", err.Error()))
+	}
 	if req.TargetPath == "" {
 		publishErr.WithLabelValues(configMap, "missing target path").Inc()
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume target path must be provided")
@@ -45,15 +59,39 @@ func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		publishErr.WithLabelValues(configMap, "missing volume capabilities").Inc()
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume volume capability must be provided")
 	}
-	d.volumeLock.Lock()
-	if d.volumeBusy[req.VolumeId] {
-		d.volumeLock.Unlock()
+
+	// duplicate requests for the same target, like kubelet retrying a publish which timed out, share the result of the
+	// request in progress instead of populating the volume again once it is released. The shared publish outlives the
+	// request which started it, so a retry of a timed out request joins it instead of failing along with it.
+	results := d.publishes.DoChan(req.VolumeId+":"+req.TargetPath, func() (interface{}, error) {
+		publishCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), publishTimeout)
+		defer cancel()
+		return nil, d.publishVolume(publishCtx, req, start)
+	})
+	select {
+	case result := <-results:
+		if result.Shared {
+			publishCoalesced.WithLabelValues(configMap).Inc()
+		}
+		if result.Err != nil {
+			return nil, result.Err
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	case <-ctx.Done():
+		publishErr.WithLabelValues(configMap, "publish in progress").Inc()
+		// https://github.com/container-storage-interface/spec/blob/master/spec.md#concurrency
+		return nil, status.Error(codes.Aborted, fmt.Sprintf("NodePublishVolume gave up waiting on the publish of volume %q in progress: %s", req.VolumeId, ctx.Err().Error()))
+	}
+}
+
+// publishVolume populates and mounts the volume of a validated publish request, once no other request holds the volume.
+func (d *driver) publishVolume(ctx context.Context, req *csi.NodePublishVolumeRequest, start time.Time) error {
+	configMap := req.VolumeContext["name"]
+	if err := d.lockVolume(ctx, "publish", req.VolumeId); err != nil {
 		publishErr.WithLabelValues(configMap, "concurrent volume publish").Inc()
 		// https://github.com/container-storage-interface/spec/blob/master/spec.md#concurrency
-		return nil, status.Error(codes.Aborted, "NodePublishVolume concurrent publish request, volume is being unpublished")
+		return status.Error(codes.Aborted, fmt.Sprintf("NodePublishVolume gave up waiting for a concurrent request on volume %q: %s", req.VolumeId, err.Error()))
 	}
-	d.volumeBusy[req.VolumeId] = true
-	d.volumeLock.Unlock()
 	defer d.unlockVolume(req.VolumeId)
 
 	mnt := req.VolumeCapability.GetMount()
@@ -76,7 +114,33 @@ func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 		TargetPath: req.TargetPath,
 		FSType:     fsType,
 		BindOpts:   options,
+		Pod: PodMeta{
+			Name:           req.VolumeContext[podNameKey],
+			Namespace:      req.VolumeContext[podNamespaceKey],
+			UID:            req.VolumeContext[podUIDKey],
+			ServiceAccount: req.VolumeContext[podServiceAccountKey],
+		},
+	}
+	var err error
+	if meta.UID, err = parseID(req.VolumeContext["uid"]); err != nil {
+		publishErr.WithLabelValues(configMap, "invalid volume uid").Inc()
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("NodePublishVolume invalid uid for volume %q: %s", req.VolumeId, err.Error()))
+	}
+	if meta.GID, err = parseID(req.VolumeContext["gid"]); err != nil {
+		publishErr.WithLabelValues(configMap, "invalid volume gid").Inc()
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("NodePublishVolume invalid gid for volume %q: %s", req.VolumeId, err.Error()))
 	}
+	// kubelet passes the fs group of the pod as the volume mount group, as the driver has the VOLUME_MOUNT_GROUP capability
+	if meta.FSGroup, err = parseID(mnt.GetVolumeMountGroup()); err != nil {
+		publishErr.WithLabelValues(configMap, "invalid volume mount group").Inc()
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("NodePublishVolume invalid volume mount group for volume %q: %s", req.VolumeId, err.Error()))
+	}
+	items, err := parseItems(req.VolumeContext["items"])
+	if err != nil {
+		publishErr.WithLabelValues(configMap, "invalid volume items").Inc()
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("NodePublishVolume invalid items for volume %q: %s", req.VolumeId, err.Error()))
+	}
+	meta.Items = items
 	if _, err := meta.FileMode(); err != nil {
 		publishErr.WithLabelValues(configMap, "invalid volume mode").Inc()
 		logger.Error(err, fmt.Sprintf("discarding invalid mode %q for volume %q", meta.Mode, req.VolumeId))
@@ -84,16 +148,31 @@ func (d *driver) NodePublishVolume(ctx context.Context, req *csi.NodePublishVolu
 	}
 
 	if err := d.publisher.Populate(ctx, meta); err != nil {
+		d.populateFailed(ctx, meta, err)
+		if errors.Is(err, errInvalidKey) {
+			publishErr.WithLabelValues(configMap, "invalid cluster config map key").Inc()
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("failed to populate volume %q: %s", req.VolumeId, err.Error()))
+		}
+		if errors.Is(err, errPermissionDenied) {
+			publishErr.WithLabelValues(configMap, "namespace not allowed").Inc()
+			return status.Error(codes.PermissionDenied, fmt.Sprintf("failed to populate volume %q: %s", req.VolumeId, err.Error()))
+		}
+		if errors.Is(err, errMissingKey) {
+			publishErr.WithLabelValues(configMap, "missing cluster config map key").Inc()
+			return status.Error(codes.InvalidArgument, fmt.Sprintf("failed to populate volume %q: %s", req.VolumeId, err.Error()))
+		}
 		publishErr.WithLabelValues(configMap, "failed to populate volume contents").Inc()
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to populate volume %q: %s", req.VolumeId, err.Error()))
+		return status.Error(codes.Internal, fmt.Sprintf("failed to populate volume %q: %s", req.VolumeId, err.Error()))
 	}
 	if err := d.publisher.Mount(ctx, meta); err != nil {
 		publishErr.WithLabelValues(configMap, "failed to mount volume contents").Inc()
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to mount volume %q: %s", req.VolumeId, err.Error()))
+		return status.Error(codes.Internal, fmt.Sprintf("failed to mount volume %q: %s", req.VolumeId, err.Error()))
 	}
+	d.reportStatus(configMap)
 	publish.WithLabelValues(configMap).Inc()
+	lastSynced.WithLabelValues(configMap, req.VolumeId).Set(float64(meta.Synced.Unix()))
 	publishTime.WithLabelValues(configMap).Observe(time.Since(start).Seconds())
-	return &csi.NodePublishVolumeResponse{}, nil
+	return nil
 }
 
 func (d *driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
@@ -102,24 +181,24 @@ func (d *driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 		unpublishErr.WithLabelValues("", "missing volume id").Inc()
 		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume Volume ID must be provided")
 	}
+	if err := validateVolumeID(req.VolumeId); err != nil {
+		unpublishErr.WithLabelValues("", "invalid volume id").Inc()
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("NodeUnpublishVolume %s", err.Error()))
+	}
 	if req.TargetPath == "" {
 		unpublishErr.WithLabelValues("", "missing target path").Inc()
 		return nil, status.Error(codes.InvalidArgument, "NodeUnpublishVolume Target Path must be provided")
 	}
-	d.volumeLock.Lock()
-	if d.volumeBusy[req.VolumeId] {
-		d.volumeLock.Unlock()
+	if err := d.lockVolume(ctx, "unpublish", req.VolumeId); err != nil {
 		unpublishErr.WithLabelValues("", "concurrent volume unpublish").Inc()
 		// https://github.com/container-storage-interface/spec/blob/master/spec.md#concurrency
-		return nil, status.Error(codes.Aborted, "NodeUnpublishVolume concurrent unpublish request")
+		return nil, status.Error(codes.Aborted, fmt.Sprintf("NodeUnpublishVolume gave up waiting for a concurrent request on volume %q: %s", req.VolumeId, err.Error()))
 	}
-	d.volumeBusy[req.VolumeId] = true
-	d.volumeLock.Unlock()
 	defer d.unlockVolume(req.VolumeId)
 	logger.V(2).Info(fmt.Sprintf("node unpublish volume called for volume id %q target path %q", req.VolumeId, req.TargetPath))
 
 	configMap := "unknown"
-	meta, err := ReadMetadata(req.VolumeId)
+	meta, err := d.volumes.ReadMetadata(req.VolumeId)
 	if err != nil {
 		// legacy code path
 		logger.Info(fmt.Sprintf("missing metadata for volume %q, attempting to handle unpublish of %q gracefully: %s", req.VolumeId, req.TargetPath, err.Error()))
@@ -130,10 +209,9 @@ func (d *driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 		}
 		configMap = meta.Name
 	}
-	mounter := mount.New("")
-	if err = mounter.Unmount(req.TargetPath); err != nil {
+	if err = d.mounter.Unmount(req.TargetPath); err != nil {
 		// check if volume was already unmounted
-		mounts, listErr := mounter.List()
+		mounts, listErr := d.mounter.List()
 		if listErr != nil {
 			logger.Error(listErr, "failed to list mounts when unmounting volume")
 			unpublishErr.WithLabelValues(configMap, "failed to unmount volume").Inc()
@@ -151,26 +229,113 @@ func (d *driver) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublish
 		logger.V(2).Info(fmt.Sprintf("failed to unmount volume %q, err was %q, did not detect the path in the system mounts, assuming it was already unmounted successfully", req.VolumeId, err.Error()))
 		unpublishErr.WithLabelValues(configMap, "volume was already unmounted").Inc()
 	}
+	// the target path was created by NodePublishVolume and must be removed again
+	// https://github.com/container-storage-interface/spec/blob/master/spec.md#nodeunpublishvolume
+	if err := os.Remove(req.TargetPath); err != nil && !os.IsNotExist(err) {
+		logger.Error(err, fmt.Sprintf("failed to remove target path %q of volume %q", req.TargetPath, req.VolumeId))
+		unpublishErr.WithLabelValues(configMap, "failed to remove target path").Inc()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err := d.removeVolume(req.VolumeId); err != nil {
+		logger.Error(err, fmt.Sprintf("failed to remove data of volume %q", req.VolumeId))
+		unpublishErr.WithLabelValues(configMap, "failed to remove volume data").Inc()
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	lastSynced.DeleteLabelValues(configMap, req.VolumeId)
+	if meta != nil {
+		d.reportStatus(configMap)
+	}
 	logger.V(2).Info(fmt.Sprintf("node unpublish volume succeeded for volume id %q target path %q", req.VolumeId, req.TargetPath))
 	unpublish.WithLabelValues(configMap).Inc()
 	unpublishTime.WithLabelValues(configMap).Observe(time.Since(start).Seconds())
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
+// NodeStageVolume is not supported, the driver does not advertise the STAGE_UNSTAGE_VOLUME capability.
 func (d *driver) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
-	panic("implement me")
+	return nil, status.Error(codes.Unimplemented, "NodeStageVolume is not supported")
 }
 
+// NodeUnstageVolume is not supported, the driver does not advertise the STAGE_UNSTAGE_VOLUME capability.
 func (d *driver) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
-	panic("implement me")
+	return nil, status.Error(codes.Unimplemented, "NodeUnstageVolume is not supported")
 }
 
-func (d *driver) NodeGetVolumeStats(ctx context.Context, in *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
-	panic("implement me")
+func (d *driver) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVolumeStatsRequest) (*csi.NodeGetVolumeStatsResponse, error) {
+	if req.VolumeId == "" {
+		volumeStatsErr.WithLabelValues("", "missing volume id").Inc()
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume ID must be provided")
+	}
+	if err := validateVolumeID(req.VolumeId); err != nil {
+		volumeStatsErr.WithLabelValues("", "invalid volume id").Inc()
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("NodeGetVolumeStats %s", err.Error()))
+	}
+	if req.VolumePath == "" {
+		volumeStatsErr.WithLabelValues("", "missing volume path").Inc()
+		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats Volume Path must be provided")
+	}
+	// the contents and metadata are not consistent while the volume is being written
+	if err := d.lockVolume(ctx, "stats", req.VolumeId); err != nil {
+		volumeStatsErr.WithLabelValues("", "concurrent volume operation").Inc()
+		return nil, status.Error(codes.Aborted, fmt.Sprintf("NodeGetVolumeStats gave up waiting for a concurrent request on volume %q: %s", req.VolumeId, err.Error()))
+	}
+	defer d.unlockVolume(req.VolumeId)
+	logger.V(4).Info(fmt.Sprintf("node get volume stats called for volume id %q volume path %q", req.VolumeId, req.VolumePath))
+
+	meta, err := d.volumes.ReadMetadata(req.VolumeId)
+	if err != nil {
+		volumeStatsErr.WithLabelValues("", "missing metadata").Inc()
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %q not found: %s", req.VolumeId, err.Error()))
+	}
+	if meta.TargetPath != req.VolumePath {
+		volumeStatsErr.WithLabelValues(meta.Name, "volume path mismatch").Inc()
+		return nil, status.Error(codes.NotFound, fmt.Sprintf("volume %q is not published at %q", req.VolumeId, req.VolumePath))
+	}
+
+	// the data dir is not recreated, a volume whose data dir was removed is reported as abnormal instead
+	var usage []*csi.VolumeUsage
+	dir := d.volumes.DataPath(meta.VolumeID)
+	populated := true
+	if _, err := os.Stat(dir); err != nil {
+		if !os.IsNotExist(err) {
+			volumeStatsErr.WithLabelValues(meta.Name, "failed to stat data dir").Inc()
+			return nil, status.Error(codes.Internal, fmt.Sprintf("failed to stat data dir %q: %s", dir, err.Error()))
+		}
+		populated = false
+	} else {
+		usage, err = volumeUsage(dir)
+		if err != nil {
+			volumeStatsErr.WithLabelValues(meta.Name, "failed to get volume usage").Inc()
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	mounted := false
+	mounts, err := d.mounter.List()
+	if err != nil {
+		volumeStatsErr.WithLabelValues(meta.Name, "failed to list mounts").Inc()
+		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to list mounts: %s", err.Error()))
+	}
+	for _, mountPoint := range mounts {
+		if mountPoint.Path == req.VolumePath {
+			mounted = true
+			break
+		}
+	}
+
+	condition := volumeCondition(meta, mounted, populated)
+	if condition.Abnormal {
+		logger.Info(fmt.Sprintf("volume %q (configmap: %q) is abnormal: %s", req.VolumeId, meta.Name, condition.Message))
+	}
+	return &csi.NodeGetVolumeStatsResponse{
+		Usage:           usage,
+		VolumeCondition: condition,
+	}, nil
 }
 
-func (d *driver) NodeExpandVolume(ctx context.Context, in *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
-	panic("implement me")
+// NodeExpandVolume is not supported, the driver does not advertise the EXPAND_VOLUME capability.
+func (d *driver) NodeExpandVolume(ctx context.Context, req *csi.NodeExpandVolumeRequest) (*csi.NodeExpandVolumeResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "NodeExpandVolume is not supported")
 }
 
 func (d *driver) NodeGetCapabilities(ctx context.Context, in *csi.NodeGetCapabilitiesRequest) (*csi.NodeGetCapabilitiesResponse, error) {
@@ -178,7 +343,21 @@ func (d *driver) NodeGetCapabilities(ctx context.Context, in *csi.NodeGetCapabil
 		{
 			Type: &csi.NodeServiceCapability_Rpc{
 				Rpc: &csi.NodeServiceCapability_RPC{
-					Type: csi.NodeServiceCapability_RPC_UNKNOWN,
+					Type: csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+				},
+			},
+		},
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+				},
+			},
+		},
+		{
+			Type: &csi.NodeServiceCapability_Rpc{
+				Rpc: &csi.NodeServiceCapability_RPC{
+					Type: csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
 				},
 			},
 		},
@@ -196,8 +375,81 @@ func (d *driver) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (
 	}, nil
 }
 
+// validateVolumeID checks the volume id is a single path element, it names the data and metadata dirs of the volume
+// within the storage dir.
+func validateVolumeID(volumeID string) error {
+	if volumeID == "." || volumeID == ".." || strings.ContainsAny(volumeID, "/\\\x00") || path.Clean(volumeID) != volumeID {
+		return fmt.Errorf("volume id %q must be a single path element", volumeID)
+	}
+	return nil
+}
+
+// removeVolume deletes the data and metadata of an unpublished volume, unless its data dir is still bind mounted
+// somewhere, in which case it is left for garbage collection. Removing a volume which is already gone succeeds.
+func (d *driver) removeVolume(volumeID string) error {
+	dataPath := d.volumes.DataPath(volumeID)
+	refs, err := d.mounter.GetMountRefs(dataPath)
+	if err != nil {
+		return fmt.Errorf("failed to lookup mount refs for %q: %w", dataPath, err)
+	}
+	if len(refs) > 0 {
+		logger.Info(fmt.Sprintf("data dir %q of volume %q is still mounted at %v, leaving it for garbage collection", dataPath, volumeID, refs))
+		return nil
+	}
+	if err := os.RemoveAll(dataPath); err != nil {
+		return fmt.Errorf("failed to remove data dir %q: %w", dataPath, err)
+	}
+	// the metadata is removed last, so the data dir is never left without its metadata
+	metadataPath := d.volumes.MetadataPath(volumeID)
+	if err := os.RemoveAll(metadataPath); err != nil {
+		return fmt.Errorf("failed to remove metadata dir %q: %w", metadataPath, err)
+	}
+	return nil
+}
+
+// lockVolume waits until no other request or refresh holds the volume and marks it as busy, or returns the error of
+// the context if it is done first. The time spent waiting is recorded for the operation.
+func (d *driver) lockVolume(ctx context.Context, operation, volumeID string) error {
+	start := time.Now()
+	defer func() {
+		volumeLockWait.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	}()
+	for {
+		d.volumeLock.Lock()
+		released, busy := d.volumeBusy[volumeID]
+		if !busy {
+			d.volumeBusy[volumeID] = make(chan struct{})
+			d.volumeLock.Unlock()
+			return nil
+		}
+		d.volumeLock.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// tryLockVolume marks the volume as busy, returning false if another request already holds it. Background work uses it
+// to skip busy volumes instead of waiting on them.
+func (d *driver) tryLockVolume(volumeID string) bool {
+	d.volumeLock.Lock()
+	defer d.volumeLock.Unlock()
+	if _, busy := d.volumeBusy[volumeID]; busy {
+		return false
+	}
+	d.volumeBusy[volumeID] = make(chan struct{})
+	return true
+}
+
+// unlockVolume releases the volume, waking up the requests waiting on it.
 func (d *driver) unlockVolume(volumeID string) {
 	d.volumeLock.Lock()
 	defer d.volumeLock.Unlock()
-	delete(d.volumeBusy, volumeID)
+	if released, busy := d.volumeBusy[volumeID]; busy {
+		close(released)
+		delete(d.volumeBusy, volumeID)
+	}
 }
@@ -0,0 +1,261 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/mount-utils"
+)
+
+// newTestVolumeStore returns a volume store in a temporary dir, which is removed at the end of the test.
+func newTestVolumeStore(t *testing.T) *volumeStore {
+	t.Helper()
+	return newVolumeStore(t.TempDir())
+}
+
+// notMountedMounter fails to unmount every path, like the real mounter does for paths which are not mounted.
+type notMountedMounter struct {
+	*mount.FakeMounter
+}
+
+func (m *notMountedMounter) Unmount(target string) error {
+	return errors.New("umount: " + target + ": not mounted")
+}
+
+func Test_NodeUnpublishVolume(t *testing.T) {
+	type testcase struct {
+		description string
+		mounted     bool
+		mounter     func(mounter *mount.FakeMounter) mount.Interface
+		code        codes.Code
+	}
+	tests := []testcase{
+		{
+			description: "a mounted volume should be unmounted",
+			mounted:     true,
+			code:        codes.OK,
+		},
+		{
+			description: "a volume which is not mounted should be unpublished",
+			mounted:     false,
+			code:        codes.OK,
+		},
+		{
+			description: "a volume which was already unmounted should be unpublished when unmount fails",
+			mounted:     false,
+			mounter: func(mounter *mount.FakeMounter) mount.Interface {
+				return &notMountedMounter{mounter}
+			},
+			code: codes.OK,
+		},
+		{
+			description: "a volume which is still mounted after unmount fails should not be unpublished",
+			mounted:     true,
+			mounter: func(mounter *mount.FakeMounter) mount.Interface {
+				mounter.UnmountFunc = func(path string) error {
+					return errors.New("device or resource busy")
+				}
+				return mounter
+			},
+			code: codes.Internal,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			volumes := newTestVolumeStore(t)
+			targetPath := path.Join(t.TempDir(), "target")
+			meta := &ClusterConfigMapMeta{Name: "test-cluster-config-maps", VolumeID: "test-volume-id", TargetPath: targetPath}
+			require.NoError(t, volumes.WriteMetadata(meta))
+			dataDir, err := volumes.DataDir(meta.VolumeID)
+			require.NoError(t, err)
+
+			fakeMounter := mount.NewFakeMounter(nil)
+			if test.mounted {
+				require.NoError(t, fakeMounter.Mount(dataDir, targetPath, "ext4", []string{"bind"}))
+			}
+			driver := newDriver("test", "", volumes, &mockVolumePublisher{})
+			driver.mounter = fakeMounter
+			if test.mounter != nil {
+				driver.mounter = test.mounter(fakeMounter)
+			}
+
+			req := &csi.NodeUnpublishVolumeRequest{VolumeId: "test-volume-id", TargetPath: targetPath}
+			_, err = driver.NodeUnpublishVolume(context.TODO(), req)
+			require.Equal(t, test.code, status.Code(err))
+			if test.code != codes.OK {
+				require.DirExists(t, dataDir, "data of volumes which are still mounted should be kept")
+				return
+			}
+			require.Empty(t, fakeMounter.MountPoints)
+			require.NoDirExists(t, dataDir, "data of unpublished volumes should be removed")
+			require.NoDirExists(t, volumes.MetadataPath("test-volume-id"), "metadata of unpublished volumes should be removed")
+
+			_, err = driver.NodeUnpublishVolume(context.TODO(), req)
+			require.NoError(t, err, "unpublish should be idempotent")
+		})
+	}
+}
+
+func Test_driver_InvalidVolumeID(t *testing.T) {
+	volumeIDs := []string{".", "..", "../..", "../../victim", "a/b", "/victim", "a\\b", "victim\x00"}
+	for _, volumeID := range volumeIDs {
+		t.Run(volumeID, func(t *testing.T) {
+			root := t.TempDir()
+			volumes := newVolumeStore(path.Join(root, "storage"))
+			victim := path.Join(root, "victim")
+			require.NoError(t, os.MkdirAll(victim, 0755))
+			targetPath := path.Join(root, "target")
+
+			driver := newDriver("test", "", volumes, &mockVolumePublisher{})
+			driver.mounter = mount.NewFakeMounter(nil)
+
+			_, err := driver.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+				VolumeId:         volumeID,
+				TargetPath:       targetPath,
+				VolumeContext:    map[string]string{"name": "test-cluster-config-maps"},
+				VolumeCapability: &csi.VolumeCapability{},
+			})
+			require.Equal(t, codes.InvalidArgument, status.Code(err), "publish")
+			_, err = driver.NodeUnpublishVolume(context.TODO(), &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: targetPath})
+			require.Equal(t, codes.InvalidArgument, status.Code(err), "unpublish")
+			_, err = driver.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: targetPath})
+			require.Equal(t, codes.InvalidArgument, status.Code(err), "stats")
+
+			require.DirExists(t, victim, "dirs outside of the storage dir should not be removed")
+			require.NoDirExists(t, volumes.dir, "the storage dir should not be touched")
+		})
+	}
+}
+
+func Test_driver_lockVolume(t *testing.T) {
+	driver := newDriver("test", "", newTestVolumeStore(t), &mockVolumePublisher{})
+	require.NoError(t, driver.lockVolume(context.TODO(), "test", "test-volume-id"))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, driver.lockVolume(ctx, "test", "test-volume-id"), context.DeadlineExceeded, "waiting should be bounded by the context")
+	require.False(t, driver.tryLockVolume("test-volume-id"))
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		driver.unlockVolume("test-volume-id")
+	})
+	require.NoError(t, driver.lockVolume(context.TODO(), "test", "test-volume-id"), "waiters should acquire the volume once it is released")
+	driver.unlockVolume("test-volume-id")
+	require.True(t, driver.tryLockVolume("test-volume-id"))
+}
+
+func Test_NodePublishVolume_Concurrent(t *testing.T) {
+	publishRequest := func(targetPath string) *csi.NodePublishVolumeRequest {
+		return &csi.NodePublishVolumeRequest{
+			VolumeId:   "test-volume-id",
+			TargetPath: targetPath,
+			VolumeCapability: &csi.VolumeCapability{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+			},
+			VolumeContext: map[string]string{
+				"name": "test-cluster-config-maps",
+			},
+		}
+	}
+
+	t.Run("publish should wait for a concurrent request on the volume instead of aborting", func(t *testing.T) {
+		mockPublisher := &mockVolumePublisher{}
+		mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
+		mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
+		driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+
+		require.True(t, driver.tryLockVolume("test-volume-id"))
+		time.AfterFunc(50*time.Millisecond, func() {
+			driver.unlockVolume("test-volume-id")
+		})
+		_, err := driver.NodePublishVolume(context.TODO(), publishRequest("/tmp/test-path"))
+		require.NoError(t, err)
+	})
+
+	t.Run("publish should abort once the request context is done", func(t *testing.T) {
+		driver := newDriver("test", "", newTestVolumeStore(t), &mockVolumePublisher{})
+		require.True(t, driver.tryLockVolume("test-volume-id"))
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+		defer cancel()
+		_, err := driver.NodePublishVolume(ctx, publishRequest("/tmp/test-path"))
+		require.Equal(t, codes.Aborted, status.Code(err))
+	})
+
+	t.Run("duplicate publish requests should be coalesced", func(t *testing.T) {
+		populating := make(chan struct{})
+		release := make(chan struct{})
+		mockPublisher := &mockVolumePublisher{}
+		mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, _ *ClusterConfigMapMeta) error {
+			close(populating)
+			<-release
+			return nil
+		}).Once()
+		mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil).Once()
+		driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+
+		wg := sync.WaitGroup{}
+		errs := make([]error, 2)
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = driver.NodePublishVolume(context.TODO(), publishRequest("/tmp/test-path"))
+			}()
+			if i == 0 {
+				<-populating
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		require.NoError(t, errs[0])
+		require.NoError(t, errs[1])
+		mockPublisher.AssertNumberOfCalls(t, "Populate", 1)
+	})
+	t.Run("retries of a timed out publish should join the publish in progress", func(t *testing.T) {
+		populating := make(chan struct{})
+		release := make(chan struct{})
+		mockPublisher := &mockVolumePublisher{}
+		mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(ctx context.Context, _ *ClusterConfigMapMeta) error {
+			close(populating)
+			<-release
+			return ctx.Err()
+		}).Once()
+		mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil).Once()
+		driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+
+		ctx, cancel := context.WithCancel(context.TODO())
+		errs := make(chan error, 1)
+		go func() {
+			_, err := driver.NodePublishVolume(ctx, publishRequest("/tmp/test-path"))
+			errs <- err
+		}()
+		<-populating
+		cancel()
+		require.Equal(t, codes.Aborted, status.Code(<-errs), "the timed out request should give up waiting")
+
+		time.AfterFunc(50*time.Millisecond, func() {
+			close(release)
+		})
+		_, err := driver.NodePublishVolume(context.TODO(), publishRequest("/tmp/test-path"))
+		require.NoError(t, err, "the retry should share the publish, which is not cancelled with the timed out request")
+		mockPublisher.AssertNumberOfCalls(t, "Populate", 1)
+	})
+}
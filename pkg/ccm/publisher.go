@@ -1,22 +1,30 @@
 package ccm
 
 import (
-	"bytes"
 	"context"
 	"crypto/sha512"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"time"
 
 	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
 
-	"k8s.io/client-go/kubernetes"
-
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/mount-utils"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// errInvalidKey is returned when a cluster config map holds keys which can not be safely materialized as files.
+var errInvalidKey = errors.New("invalid keys")
+
+// errMissingKey is returned when a volume selects a key which is not present in the cluster config map.
+var errMissingKey = errors.New("missing key")
+
 //go:generate go run github.com/vektra/mockery/v2 --name=VolumePublisher --inpackage --structname=mockVolumePublisher
 
 // VolumePublisher handles the mounting of volume and populating the content for the volume mount.
@@ -28,16 +36,32 @@ type VolumePublisher interface {
 }
 
 type nodePublisher struct {
-	client *kubernetes.Clientset
+	// volumes holds the data and metadata of the volumes populated by the publisher.
+	volumes *volumeStore
+	// recorder posts events on cluster config maps, events are disabled when nil.
+	recorder record.EventRecorder
+	// mounter bind mounts the data dir of volumes to their target path.
+	mounter mount.Interface
+	// cache serves cluster config maps from the node plugin's informer.
+	cache client.Reader
+	// reader reads cluster config maps directly from the apiserver.
+	reader client.Reader
+	// liveReadFallback enables reading from the apiserver when a cluster config map is missing from the cache.
+	liveReadFallback bool
+	// snapshots, if set, keeps the last known good contents of cluster config maps to publish from when the
+	// apiserver is unreachable.
+	snapshots *snapshotStore
 }
 
+// snapshotReadTimeout bounds the time spent waiting on the cache and the apiserver before publishing from a snapshot,
+// so the publish request can still complete before kubelet gives up on it.
+const snapshotReadTimeout = 15 * time.Second
+
 var _ VolumePublisher = (*nodePublisher)(nil)
 
 func (n *nodePublisher) Mount(ctx context.Context, meta *ClusterConfigMapMeta) error {
-	mounter := mount.New("")
-
 	// clean up the mount if it already exists but is not valid
-	notMnt, err := mounter.IsLikelyNotMountPoint(meta.TargetPath)
+	notMnt, err := n.mounter.IsLikelyNotMountPoint(meta.TargetPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			if err = os.MkdirAll(meta.TargetPath, 0750); err != nil {
@@ -53,7 +77,7 @@ func (n *nodePublisher) Mount(ctx context.Context, meta *ClusterConfigMapMeta) e
 		return nil
 	}
 
-	if err := mounter.Mount(meta.Directory.Path, meta.TargetPath, meta.FSType, meta.BindOpts); err != nil {
+	if err := n.mounter.Mount(meta.Directory.Path, meta.TargetPath, meta.FSType, meta.BindOpts); err != nil {
 		return fmt.Errorf("failed to bind mount %q to %q with fs %q", meta.Directory.Path, meta.TargetPath, meta.FSType)
 	}
 
@@ -61,39 +85,43 @@ func (n *nodePublisher) Mount(ctx context.Context, meta *ClusterConfigMapMeta) e
 }
 
 func (n *nodePublisher) Populate(ctx context.Context, ccmm *ClusterConfigMapMeta) error {
-	dir, err := ccmm.DataDir()
+	dir, err := n.volumes.DataDir(ccmm.VolumeID)
 	if err != nil {
 		return err
 	}
-	absPath := fmt.Sprintf("/apis/%s/%s/clusterconfigmaps/%s", v1alpha1.Group, v1alpha1.Version, ccmm.Name)
-	logger.V(3).Info("querying for ccm: " + absPath)
-	ccmBytes, err := n.client.RESTClient().Get().AbsPath(absPath).DoRaw(ctx)
+	ccm, err := n.read(ctx, ccmm.Name)
 	if err != nil {
 		return fmt.Errorf("failed to read cluster configmap: %w", err)
 	}
-	var ccm v1alpha1.ClusterConfigMap
-	if err := json.NewDecoder(bytes.NewReader(ccmBytes)).Decode(&ccm); err != nil {
-		logger.V(5).Info("failed to decide cluster config map: %s", string(ccmBytes))
-		return fmt.Errorf("failed to decode cluster configmap: %w", err)
+
+	if err := n.authorize(ctx, ccm, ccmm.Pod.Namespace); err != nil {
+		return err
+	}
+	if errs := ccm.Validate(); len(errs) > 0 {
+		return fmt.Errorf("%w in cluster configmap %q: %s", errInvalidKey, ccm.Name, errs.ToAggregate().Error())
+	}
+
+	contents := make(map[string][]byte, len(ccm.Data)+len(ccm.BinaryData))
+	for key, value := range ccm.Data {
+		contents[key] = []byte(value)
+	}
+	for key, value := range ccm.BinaryData {
+		contents[key] = value
+	}
+
+	payload, err := projectContents(ccmm, contents, ccm.FileAttributes)
+	if err != nil {
+		return err
 	}
 
 	meta := DirectoryMeta{
 		Path:     dir,
-		Contents: make([]ContentMeta, 0, len(ccm.Data)),
+		Contents: make([]ContentMeta, 0, len(payload)),
 	}
-	mode, _ := ccmm.FileMode()
-
 	sha := sha512.New()
-	for filename, contents := range ccm.Data {
-		target := path.Join(dir, filename)
-		logger.V(5).Info("writing data to target " + target)
-
-		err = os.WriteFile(target, []byte(contents), mode)
-		if err != nil {
-			return fmt.Errorf("failed to write configmap to target %q: %w", target, err)
-		}
+	for filename, file := range payload {
 		sha.Reset()
-		_, _ = sha.Write([]byte(contents))
+		_, _ = sha.Write(file.Data)
 		checksumStr := hex.EncodeToString(sha.Sum(nil))
 		meta.Contents = append(meta.Contents, ContentMeta{
 			Filename: filename,
@@ -101,10 +129,137 @@ func (n *nodePublisher) Populate(ctx context.Context, ccmm *ClusterConfigMapMeta
 		})
 	}
 
+	uid, gid := ccmm.Owner()
+	writer := &atomicWriter{targetDir: dir, uid: uid, gid: gid}
+	if err := writer.Write(payload); err != nil {
+		return fmt.Errorf("failed to write configmap to %q: %w", dir, err)
+	}
+
 	ccmm.Directory = meta
-	if err = ccmm.WriteMetadata(); err != nil {
+	ccmm.ClusterConfigMapUID = ccm.UID
+	ccmm.ResourceVersion = ccm.ResourceVersion
+	ccmm.Generation = ccm.Generation
+	ccmm.Hash = contentHash(ccm)
+	ccmm.Synced = time.Now()
+	if err = n.volumes.WriteMetadata(ccmm); err != nil {
 		return fmt.Errorf("failed to persist metadata for volume %q: %w", ccmm.VolumeID, err)
 	}
 
 	return nil
 }
+
+// projectContents maps the contents of the cluster config map to the files of the volume. Every key is written to a
+// file of the same name, unless the volume selects individual keys with items. The mode of a file is taken from the
+// item, the file attributes of the key, or the volume, in that order of precedence. The ownership of a file is taken
+// from the file attributes of the key, or the volume, falling back to the fs group of the pod for the group. Files
+// owned by the fs group are always readable by the group, the same as kubelet does for native ConfigMap volumes.
+func projectContents(ccmm *ClusterConfigMapMeta, contents map[string][]byte, attributes map[string]v1alpha1.FileAttributes) (map[string]fileProjection, error) {
+	mode, _ := ccmm.FileMode()
+	uid, gid := ccmm.Owner()
+	project := func(key string, data []byte, itemMode string) (fileProjection, error) {
+		file := fileProjection{Data: data, Mode: mode, UID: uid, GID: gid}
+		attrs := attributes[key]
+		if itemMode == "" {
+			itemMode = attrs.Mode
+		}
+		if itemMode != "" {
+			parsedMode, err := parseMode(itemMode)
+			if err != nil {
+				return file, fmt.Errorf("failed to parse mode %q for key %q: %w", itemMode, key, err)
+			}
+			file.Mode = parsedMode
+		}
+		if attrs.UID != nil {
+			file.UID = attrs.UID
+		}
+		if attrs.GID != nil {
+			file.GID = attrs.GID
+		} else if ccmm.GID == nil && ccmm.FSGroup != nil {
+			file.Mode |= 0040
+		}
+		return file, nil
+	}
+
+	if len(ccmm.Items) == 0 {
+		payload := make(map[string]fileProjection, len(contents))
+		for key, data := range contents {
+			file, err := project(key, data, "")
+			if err != nil {
+				return nil, err
+			}
+			payload[key] = file
+		}
+		return payload, nil
+	}
+
+	payload := make(map[string]fileProjection, len(ccmm.Items))
+	for _, item := range ccmm.Items {
+		data, ok := contents[item.Key]
+		if !ok {
+			return nil, fmt.Errorf("%w: cluster configmap %q has no key %q", errMissingKey, ccmm.Name, item.Key)
+		}
+		file, err := project(item.Key, data, item.Mode)
+		if err != nil {
+			return nil, err
+		}
+		payload[item.Path] = file
+	}
+	return payload, nil
+}
+
+// read returns the named cluster config map, or its last known good snapshot when it can not be read from the cache
+// or the apiserver and snapshots are enabled. Snapshots are never used for cluster config maps known to be deleted.
+func (n *nodePublisher) read(ctx context.Context, name string) (*v1alpha1.ClusterConfigMap, error) {
+	if n.snapshots == nil {
+		return n.get(ctx, name)
+	}
+
+	readCtx, cancel := context.WithTimeout(ctx, snapshotReadTimeout)
+	defer cancel()
+	ccm, err := n.get(readCtx, name)
+	if err == nil {
+		if saveErr := n.snapshots.Save(ccm); saveErr != nil {
+			logger.Error(saveErr, fmt.Sprintf("failed to save snapshot of cluster config map %q", name))
+			snapshotErr.WithLabelValues(name, "failed to save snapshot").Inc()
+		}
+		return ccm, nil
+	}
+	if apierrors.IsNotFound(err) {
+		return nil, err
+	}
+
+	snapshot, snapshotLoadErr := n.snapshots.Load(name)
+	if snapshotLoadErr != nil {
+		snapshotErr.WithLabelValues(name, "failed to load snapshot").Inc()
+		return nil, fmt.Errorf("%w, and no snapshot is available: %s", err, snapshotLoadErr.Error())
+	}
+	logger.Info(fmt.Sprintf("failed to read cluster config map %q, publishing snapshot at resource version %q: %s", name, snapshot.ResourceVersion, err.Error()))
+	snapshotFallback.WithLabelValues(name).Inc()
+	if n.recorder != nil {
+		n.recorder.Eventf(snapshot, corev1.EventTypeWarning, reasonSnapshotFallback, "Publishing the snapshot at resource version %q, the cluster config map could not be read: %s", snapshot.ResourceVersion, err.Error())
+	}
+	return snapshot, nil
+}
+
+// get returns the named cluster config map from the cache, falling back to a live read when it is missing from the
+// cache and the fallback is enabled. The informer may lag behind the apiserver for recently created cluster config maps.
+func (n *nodePublisher) get(ctx context.Context, name string) (*v1alpha1.ClusterConfigMap, error) {
+	ccm := &v1alpha1.ClusterConfigMap{}
+	if err := n.getObject(ctx, client.ObjectKey{Name: name}, ccm); err != nil {
+		return nil, err
+	}
+	return ccm, nil
+}
+
+// getObject reads the object from the cache, falling back to a live read when it is missing from the cache and the
+// fallback is enabled.
+func (n *nodePublisher) getObject(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := n.cache.Get(ctx, key, obj)
+	if err == nil || !n.liveReadFallback {
+		return err
+	}
+
+	logger.V(3).Info(fmt.Sprintf("cache miss for %T %q, falling back to a live read: %s", obj, key.Name, err.Error()))
+	cacheMiss.WithLabelValues(key.Name).Inc()
+	return n.reader.Get(ctx, key, obj)
+}
@@ -2,13 +2,28 @@ package ccm
 
 import (
 	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
 	"os"
+	"path"
 	"testing"
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func Test_NodePublishVolume(t *testing.T) {
@@ -35,13 +50,13 @@ func Test_NodePublishVolume(t *testing.T) {
 			},
 			publisher: func() *mockVolumePublisher {
 				mockPublisher := &mockVolumePublisher{}
-				mockPublisher.On("Populate", context.TODO(), mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
+				mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
 					require.Equal(t, "test-volume-id", meta.VolumeID)
 					require.Equal(t, "/tmp/test-path", meta.TargetPath)
 					require.Equal(t, "test-cluster-config-maps", meta.Name)
 					return nil
 				})
-				mockPublisher.On("Mount", context.TODO(), mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
+				mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
 				return mockPublisher
 			},
 		},
@@ -62,7 +77,7 @@ func Test_NodePublishVolume(t *testing.T) {
 			},
 			publisher: func() *mockVolumePublisher {
 				mockPublisher := &mockVolumePublisher{}
-				mockPublisher.On("Populate", context.TODO(), mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
+				mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
 					require.Equal(t, "test-volume-id", meta.VolumeID)
 					require.Equal(t, "/tmp/test-path", meta.TargetPath)
 					require.Equal(t, "test-cluster-config-maps", meta.Name)
@@ -72,7 +87,43 @@ func Test_NodePublishVolume(t *testing.T) {
 					require.Equal(t, os.ModePerm, mode)
 					return nil
 				})
-				mockPublisher.On("Mount", context.TODO(), mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
+				mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
+				return mockPublisher
+			},
+		},
+		{
+			description: "node publish volume should succeed with ownership and pod info",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume-id",
+				TargetPath: "/tmp/test-path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{
+							VolumeMountGroup: "3000",
+						},
+					},
+				},
+				VolumeContext: map[string]string{
+					"name":          "test-cluster-config-maps",
+					"uid":           "1000",
+					podNamespaceKey: "test-namespace",
+					podNameKey:      "test-pod",
+				},
+			},
+			publisher: func() *mockVolumePublisher {
+				mockPublisher := &mockVolumePublisher{}
+				mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
+					require.Equal(t, int64(1000), *meta.UID)
+					require.Nil(t, meta.GID)
+					require.Equal(t, int64(3000), *meta.FSGroup)
+					uid, gid := meta.Owner()
+					require.Equal(t, int64(1000), *uid)
+					require.Equal(t, int64(3000), *gid, "the group should default to the fs group")
+					require.Equal(t, "test-namespace", meta.Pod.Namespace)
+					require.Equal(t, "test-pod", meta.Pod.Name)
+					return nil
+				})
+				mockPublisher.On("Mount", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(nil)
 				return mockPublisher
 			},
 		},
@@ -80,7 +131,7 @@ func Test_NodePublishVolume(t *testing.T) {
 
 	for _, test := range tests {
 		mockPublisher := test.publisher()
-		driver := newDriver("test", "", mockPublisher)
+		driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
 
 		_, err := driver.NodePublishVolume(context.TODO(), test.req)
 		require.NoError(t, err, test.description)
@@ -110,11 +161,28 @@ func Test_NodePublishVolume_Error(t *testing.T) {
 			},
 			err: "NodePublishVolume volume context name field should be set",
 		},
+		{
+			description: "node publish volume should reject invalid uids",
+			req: &csi.NodePublishVolumeRequest{
+				VolumeId:   "test-volume-id",
+				TargetPath: "/tmp/test-path",
+				VolumeCapability: &csi.VolumeCapability{
+					AccessType: &csi.VolumeCapability_Mount{
+						Mount: &csi.VolumeCapability_MountVolume{},
+					},
+				},
+				VolumeContext: map[string]string{
+					"name": "test-cluster-config-maps",
+					"uid":  "-1",
+				},
+			},
+			err: "NodePublishVolume invalid uid",
+		},
 	}
 
 	for _, test := range tests {
 		mockPublisher := &mockVolumePublisher{}
-		driver := newDriver("test", "", mockPublisher)
+		driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
 
 		_, err := driver.NodePublishVolume(context.TODO(), test.req)
 		require.Error(t, err, test.description)
@@ -122,3 +190,162 @@ func Test_NodePublishVolume_Error(t *testing.T) {
 		mockPublisher.AssertExpectations(t)
 	}
 }
+
+func Test_NodePublishVolume_InvalidKey(t *testing.T) {
+	mockPublisher := &mockVolumePublisher{}
+	mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(fmt.Errorf("%w: path %q must be relative", errInvalidKey, "/etc/passwd"))
+	driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+
+	_, err := driver.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume-id",
+		TargetPath: "/tmp/test-path",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{
+			"name": "test-cluster-config-maps",
+		},
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	mockPublisher.AssertExpectations(t)
+}
+
+func Test_NodePublishVolume_PermissionDenied(t *testing.T) {
+	mockPublisher := &mockVolumePublisher{}
+	mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(fmt.Errorf("%w: namespace %q is not allowed", errPermissionDenied, "test-namespace"))
+	driver := newDriver("test", "", newTestVolumeStore(t), mockPublisher)
+
+	_, err := driver.NodePublishVolume(context.TODO(), &csi.NodePublishVolumeRequest{
+		VolumeId:   "test-volume-id",
+		TargetPath: "/tmp/test-path",
+		VolumeCapability: &csi.VolumeCapability{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+		},
+		VolumeContext: map[string]string{
+			"name":          "test-cluster-config-maps",
+			podNamespaceKey: "test-namespace",
+		},
+	})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	mockPublisher.AssertExpectations(t)
+}
+
+func Test_nodePublisher_get(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-config-maps"},
+		Data:       map[string]string{"foo": "bar"},
+	}
+
+	type testcase struct {
+		description      string
+		cached           bool
+		liveReadFallback bool
+		found            bool
+	}
+	tests := []testcase{
+		{
+			description: "cluster config maps should be served from the cache",
+			cached:      true,
+			found:       true,
+		},
+		{
+			description:      "cache misses should be read from the apiserver when the fallback is enabled",
+			liveReadFallback: true,
+			found:            true,
+		},
+		{
+			description: "cache misses should fail when the fallback is disabled",
+		},
+	}
+
+	for _, test := range tests {
+		cache := fake.NewClientBuilder().WithScheme(scheme)
+		if test.cached {
+			cache.WithObjects(ccm.DeepCopy())
+		}
+		publisher := &nodePublisher{
+			cache:            cache.Build(),
+			reader:           fake.NewClientBuilder().WithScheme(scheme).WithObjects(ccm.DeepCopy()).Build(),
+			liveReadFallback: test.liveReadFallback,
+		}
+
+		got, err := publisher.get(context.TODO(), "test-cluster-config-maps")
+		if !test.found {
+			require.True(t, apierrors.IsNotFound(err), test.description)
+			continue
+		}
+		require.NoError(t, err, test.description)
+		require.Equal(t, ccm.Data, got.Data, test.description)
+	}
+}
+
+func Test_nodePublisher_Populate(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+
+	type testcase struct {
+		description string
+		data        map[string]string
+		binaryData  map[string][]byte
+	}
+	tests := []testcase{
+		{
+			description: "data keys should be written as files",
+			data:        map[string]string{"config.yaml": "key: value"},
+		},
+		{
+			description: "binary data keys should be written byte for byte, including bytes which are not valid utf-8",
+			data:        map[string]string{"config.yaml": "key: value"},
+			binaryData: map[string][]byte{
+				"keystore.jks": {0xfe, 0xed, 0xfe, 0xed, 0x00, 0x00, 0x00, 0x02},
+				"invalid.bin":  {0xff, 0xc3, 0x28, 0x80, 0x00, 0x0a, 0x0d},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			volumes := newTestVolumeStore(t)
+			ccm := &v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-config-maps"},
+				Data:       test.data,
+				BinaryData: test.binaryData,
+			}
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ccm).Build()
+			publisher := &nodePublisher{volumes: volumes, cache: c, reader: c}
+
+			meta := &ClusterConfigMapMeta{Name: ccm.Name, VolumeID: "test-volume-id"}
+			require.NoError(t, publisher.Populate(context.TODO(), meta))
+
+			expected := make(map[string][]byte, len(test.data)+len(test.binaryData))
+			for key, value := range test.data {
+				expected[key] = []byte(value)
+			}
+			for key, value := range test.binaryData {
+				expected[key] = value
+			}
+			require.Len(t, meta.Directory.Contents, len(expected))
+			for _, content := range meta.Directory.Contents {
+				written, err := os.ReadFile(path.Join(meta.Directory.Path, content.Filename))
+				require.NoError(t, err)
+				require.Equal(t, expected[content.Filename], written, "file %q should hold the exact bytes of its key", content.Filename)
+				checksum := sha512.Sum512(expected[content.Filename])
+				require.Equal(t, hex.EncodeToString(checksum[:]), content.SHA512, "the checksum of %q should cover its bytes", content.Filename)
+			}
+			require.Equal(t, contentHash(ccm), meta.Hash)
+
+			// the recorded hash changes with every byte of binary data
+			for key, value := range test.binaryData {
+				changed := ccm.DeepCopy()
+				changed.BinaryData[key] = append(append([]byte{}, value[:len(value)-1]...), value[len(value)-1]^0x01)
+				require.NotEqual(t, meta.Hash, contentHash(changed), "changing binary key %q should change the hash", key)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var errVolumeBusy = errors.New("volume is busy")
+
+// refreshVolumes repopulates the data dir of every volume published on this node for the named cluster config map,
+// skipping volumes which were already populated from the given uid and generation. Status updates don't change the
+// generation, so they never cause a refresh. Volumes locked by an in-flight publish or unpublish request are skipped, and reported
+// with errVolumeBusy so the refresh can be retried.
+func (d *driver) refreshVolumes(ctx context.Context, name string, uid types.UID, generation int64) error {
+	metas, err := d.volumes.ListMetadata()
+	if err != nil {
+		refreshErr.WithLabelValues(name, "failed to list volume metadata").Inc()
+		return fmt.Errorf("failed to list volume metadata: %w", err)
+	}
+
+	var errs []error
+	refreshed := 0
+	for _, meta := range metas {
+		if meta.Name != name || meta.SyncedTo(uid, generation) {
+			continue
+		}
+		if !d.tryLockVolume(meta.VolumeID) {
+			refreshErr.WithLabelValues(name, "concurrent volume refresh").Inc()
+			errs = append(errs, fmt.Errorf("failed to refresh volume %q: %w", meta.VolumeID, errVolumeBusy))
+			continue
+		}
+		err := d.refreshVolume(ctx, meta.VolumeID, uid, generation)
+		d.unlockVolume(meta.VolumeID)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		refreshed++
+	}
+	if refreshed > 0 {
+		d.event(d.clusterConfigMapRef(ctx, name), corev1.EventTypeNormal, reasonRefreshed, "Refreshed %d volumes to generation %d", refreshed, generation)
+		d.reportStatus(name)
+	}
+	return errors.Join(errs...)
+}
+
+// refreshVolume repopulates a single volume. It must be called while holding the volume lock.
+func (d *driver) refreshVolume(ctx context.Context, volumeID string, uid types.UID, generation int64) error {
+	// the volume may have been unpublished and cleaned up while waiting on the lock, don't recreate it
+	dataPath := d.volumes.DataPath(volumeID)
+	if _, err := os.Stat(dataPath); err != nil {
+		if os.IsNotExist(err) {
+			logger.V(2).Info(fmt.Sprintf("skipping refresh of volume %q, data dir no longer exists", volumeID))
+			return nil
+		}
+		return fmt.Errorf("failed to stat data dir %q: %w", dataPath, err)
+	}
+
+	// re-read the metadata under the lock, a publish request may have updated it in the meantime
+	meta, err := d.volumes.ReadMetadata(volumeID)
+	if err != nil {
+		refreshErr.WithLabelValues("unknown", "missing volume metadata").Inc()
+		return fmt.Errorf("failed to read metadata for volume %q: %w", volumeID, err)
+	}
+	if meta.SyncedTo(uid, generation) {
+		return nil
+	}
+
+	logger.V(2).Info(fmt.Sprintf("refreshing volume %q for cluster config map %q", volumeID, meta.Name))
+	if err := d.publisher.Populate(ctx, meta); err != nil {
+		refreshErr.WithLabelValues(meta.Name, "failed to populate volume contents").Inc()
+		d.event(podRef(meta.Pod), corev1.EventTypeWarning, reasonRefreshFailed, "Failed to refresh volume %q from cluster config map %q: %s", volumeID, meta.Name, err.Error())
+		return fmt.Errorf("failed to refresh volume %q: %w", volumeID, err)
+	}
+	d.event(podRef(meta.Pod), corev1.EventTypeNormal, reasonRefreshed, "Refreshed volume %q to resource version %q of cluster config map %q", volumeID, meta.ResourceVersion, meta.Name)
+	refresh.WithLabelValues(meta.Name).Inc()
+	lastSynced.WithLabelValues(meta.Name, volumeID).Set(float64(time.Now().Unix()))
+	return nil
+}
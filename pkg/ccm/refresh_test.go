@@ -0,0 +1,103 @@
+package ccm
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_refreshVolumes(t *testing.T) {
+	type testcase struct {
+		description string
+		uid         types.UID
+		generation  int64
+		busy        bool
+		deleted     bool
+		refreshed   bool
+		err         error
+	}
+	tests := []testcase{
+		{
+			description: "volumes populated from an older generation should be refreshed",
+			uid:         "test-uid",
+			generation:  2,
+			refreshed:   true,
+		},
+		{
+			description: "volumes populated from the same generation should be skipped",
+			uid:         "test-uid",
+			generation:  1,
+			refreshed:   false,
+		},
+		{
+			description: "volumes of cluster config maps created again at the same generation should be refreshed",
+			uid:         "recreated-uid",
+			generation:  1,
+			refreshed:   true,
+		},
+		{
+			description: "busy volumes should be skipped and reported so the refresh is retried",
+			uid:         "test-uid",
+			generation:  2,
+			busy:        true,
+			refreshed:   false,
+			err:         errVolumeBusy,
+		},
+		{
+			description: "volumes whose data dir was deleted should not be recreated",
+			uid:         "test-uid",
+			generation:  2,
+			deleted:     true,
+			refreshed:   false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			volumes := newTestVolumeStore(t)
+			meta := &ClusterConfigMapMeta{Name: "test-cluster-config-maps", VolumeID: "test-volume-id", ClusterConfigMapUID: "test-uid", Generation: 1}
+			require.NoError(t, volumes.WriteMetadata(meta))
+			dataDir, err := volumes.DataDir(meta.VolumeID)
+			require.NoError(t, err)
+			other := &ClusterConfigMapMeta{Name: "other-cluster-config-maps", VolumeID: "other-volume-id", Generation: 1}
+			require.NoError(t, volumes.WriteMetadata(other))
+			if test.deleted {
+				require.NoError(t, os.RemoveAll(dataDir))
+			}
+
+			mockPublisher := &mockVolumePublisher{}
+			mockPublisher.On("Populate", mock.Anything, mock.AnythingOfType("*ccm.ClusterConfigMapMeta")).Return(func(_ context.Context, meta *ClusterConfigMapMeta) error {
+				meta.ClusterConfigMapUID = test.uid
+				meta.Generation = test.generation
+				return volumes.WriteMetadata(meta)
+			})
+			driver := newDriver("test", "", volumes, mockPublisher)
+			if test.busy {
+				require.True(t, driver.tryLockVolume("test-volume-id"))
+			}
+
+			err = driver.refreshVolumes(context.TODO(), "test-cluster-config-maps", test.uid, test.generation)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+			}
+			if test.refreshed {
+				mockPublisher.AssertNumberOfCalls(t, "Populate", 1)
+				refreshed, err := volumes.ReadMetadata("test-volume-id")
+				require.NoError(t, err)
+				require.True(t, refreshed.SyncedTo(test.uid, test.generation))
+			} else {
+				mockPublisher.AssertNotCalled(t, "Populate", mock.Anything, mock.Anything)
+			}
+			if test.deleted {
+				require.NoDirExists(t, dataDir, "the data dir of a deleted volume should not be recreated")
+			}
+		})
+	}
+}
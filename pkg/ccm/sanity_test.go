@@ -0,0 +1,124 @@
+package ccm
+
+import (
+	"context"
+	"net"
+	"path"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/kubernetes-csi/csi-test/v5/pkg/sanity"
+	"github.com/onsi/ginkgo/v2"
+	"github.com/onsi/gomega"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/mount-utils"
+)
+
+// fakeVolumePublisher publishes empty volumes, bind mounting them with the mounter of the node publisher.
+type fakeVolumePublisher struct {
+	*nodePublisher
+}
+
+func (f *fakeVolumePublisher) Populate(_ context.Context, meta *ClusterConfigMapMeta) error {
+	dir, err := f.volumes.DataDir(meta.VolumeID)
+	if err != nil {
+		return err
+	}
+	meta.Directory.Path = dir
+	return nil
+}
+
+// sanityController is the controller service csi-sanity expects next to the node service. The driver only serves
+// inline volumes, so it stands in for kubelet, which makes up their ids and passes the volume attributes of the pod as
+// volume context. It advertises no known capabilities, csi-sanity rejects an empty list.
+type sanityController struct {
+	csi.UnimplementedControllerServer
+}
+
+func (*sanityController) CreateVolume(_ context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	return &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      req.Name,
+			VolumeContext: req.Parameters,
+		},
+	}, nil
+}
+
+func (*sanityController) DeleteVolume(context.Context, *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+func (*sanityController) ControllerGetCapabilities(context.Context, *csi.ControllerGetCapabilitiesRequest) (*csi.ControllerGetCapabilitiesResponse, error) {
+	return &csi.ControllerGetCapabilitiesResponse{
+		Capabilities: []*csi.ControllerServiceCapability{
+			{
+				Type: &csi.ControllerServiceCapability_Rpc{
+					Rpc: &csi.ControllerServiceCapability_RPC{
+						Type: csi.ControllerServiceCapability_RPC_UNKNOWN,
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+// serveSanity serves srv over a unix socket in the directory of the test, and returns its endpoint.
+func serveSanity(t *testing.T, srv *grpc.Server) string {
+	t.Helper()
+	socket := path.Join(t.TempDir(), "csi.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	t.Cleanup(srv.Stop)
+	return "unix://" + socket
+}
+
+// Test_Sanity runs the csi-sanity suite against the driver served the same way as Run, with a fake mounter. The
+// controller service specs are skipped, the driver doesn't provide one.
+func Test_Sanity(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	mounter := mount.NewFakeMounter(nil)
+	driver := newDriver("test-node", "", volumes, &fakeVolumePublisher{&nodePublisher{volumes: volumes, mounter: mounter}})
+	driver.mounter = mounter
+
+	controller := grpc.NewServer()
+	csi.RegisterControllerServer(controller, &sanityController{})
+
+	config := sanity.NewTestConfig()
+	config.Address = serveSanity(t, driver.newServer())
+	config.ControllerAddress = serveSanity(t, controller)
+	config.TargetPath = path.Join(t.TempDir(), "target")
+	config.StagingPath = path.Join(t.TempDir(), "staging")
+	config.TestVolumeParameters = map[string]string{
+		"name": "test-cluster-config-maps",
+	}
+
+	sc := sanity.GinkgoTest(&config)
+	defer sc.Finalize()
+	suiteConfig, reporterConfig := ginkgo.GinkgoConfiguration()
+	suiteConfig.SkipStrings = append(suiteConfig.SkipStrings, "\\[Controller Server\\]")
+	gomega.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "CSI Driver Test Suite", suiteConfig, reporterConfig)
+}
+
+func Test_recoveryHandler(t *testing.T) {
+	info := &grpc.UnaryServerInfo{FullMethod: "/csi.v1.Node/NodeGetInfo"}
+	_, err := recoveryHandler(context.TODO(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("implement me")
+	})
+	require.Equal(t, codes.Internal, status.Code(err))
+
+	resp, err := recoveryHandler(context.TODO(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return &csi.NodeGetInfoResponse{}, nil
+	})
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+}
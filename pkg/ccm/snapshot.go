@@ -0,0 +1,106 @@
+package ccm
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+)
+
+// snapshotStore persists the last known good contents of every cluster config map published on the node, so volumes
+// can still be published while the apiserver is unreachable, for example when a node reboots during a control plane
+// outage. Snapshots are stored as the json of the cluster config map, including its resource version.
+type snapshotStore struct {
+	dir string
+
+	lock sync.Mutex
+	// versions tracks the resource version of every snapshot written by this process, to skip redundant writes.
+	versions map[string]string
+}
+
+func newSnapshotStore(dir string) *snapshotStore {
+	return &snapshotStore{
+		dir:      dir,
+		versions: make(map[string]string),
+	}
+}
+
+// Save persists the cluster config map as the last known good snapshot, unless it was already saved at the same
+// resource version.
+func (s *snapshotStore) Save(ccm *v1alpha1.ClusterConfigMap) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.versions[ccm.Name] == ccm.ResourceVersion {
+		return nil
+	}
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create snapshot dir %q: %w", s.dir, err)
+	}
+	bytes, err := json.Marshal(ccm)
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %w", err)
+	}
+
+	// write to a temporary file first, so a crash never leaves a truncated snapshot behind
+	target := s.path(ccm.Name)
+	tmp := target + ".tmp"
+	if err := os.WriteFile(tmp, bytes, 0600); err != nil {
+		return fmt.Errorf("failed to write snapshot %q: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		return fmt.Errorf("failed to rename snapshot %q: %w", tmp, err)
+	}
+	s.versions[ccm.Name] = ccm.ResourceVersion
+	return nil
+}
+
+// Load reads the last known good snapshot of the named cluster config map.
+func (s *snapshotStore) Load(name string) (*v1alpha1.ClusterConfigMap, error) {
+	bytes, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot of %q: %w", name, err)
+	}
+	ccm := &v1alpha1.ClusterConfigMap{}
+	if err := json.Unmarshal(bytes, ccm); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal snapshot of %q: %w", name, err)
+	}
+	return ccm, nil
+}
+
+// List returns the names of the cluster config maps with a snapshot.
+func (s *snapshotStore) List() ([]string, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list snapshots in %q: %w", s.dir, err)
+	}
+	var names []string
+	for _, dirEntry := range dirEntries {
+		if name, ok := strings.CutSuffix(dirEntry.Name(), ".json"); ok && dirEntry.Type().IsRegular() {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// Delete removes the snapshot of the named cluster config map, if any.
+func (s *snapshotStore) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := os.Remove(s.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete snapshot of %q: %w", name, err)
+	}
+	delete(s.versions, name)
+	return nil
+}
+
+func (s *snapshotStore) path(name string) string {
+	return path.Join(s.dir, name+".json")
+}
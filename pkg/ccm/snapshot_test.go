@@ -0,0 +1,95 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_snapshotStore(t *testing.T) {
+	snapshots := newSnapshotStore(t.TempDir())
+
+	_, err := snapshots.Load("test-cluster-config-maps")
+	require.Error(t, err, "loading a missing snapshot should fail")
+
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-config-maps", ResourceVersion: "1"},
+		Data:       map[string]string{"foo": "bar"},
+		BinaryData: map[string][]byte{"baz": {0xde, 0xad}},
+	}
+	require.NoError(t, snapshots.Save(ccm))
+
+	snapshot, err := snapshots.Load("test-cluster-config-maps")
+	require.NoError(t, err)
+	require.Equal(t, "1", snapshot.ResourceVersion)
+	require.Equal(t, ccm.Data, snapshot.Data)
+	require.Equal(t, ccm.BinaryData, snapshot.BinaryData)
+}
+
+func Test_nodePublisher_read_Snapshot(t *testing.T) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster-config-maps", ResourceVersion: "1"},
+		Data:       map[string]string{"foo": "bar"},
+	}
+
+	type testcase struct {
+		description string
+		getErr      error
+		found       bool
+	}
+	tests := []testcase{
+		{
+			description: "the snapshot should be published when the apiserver is unreachable",
+			getErr:      errors.New("connection refused"),
+			found:       true,
+		},
+		{
+			description: "the snapshot should not be published when the cluster config map was deleted",
+			getErr:      apierrors.NewNotFound(v1alpha1.SchemeGroupVersion.WithResource("clusterconfigmaps").GroupResource(), ccm.Name),
+		},
+	}
+
+	for _, test := range tests {
+		snapshots := newSnapshotStore(t.TempDir())
+		require.NoError(t, snapshots.Save(ccm), test.description)
+
+		unreachable := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Get: func(_ context.Context, _ client.WithWatch, _ client.ObjectKey, _ client.Object, _ ...client.GetOption) error {
+				return test.getErr
+			},
+		}).Build()
+		recorder := record.NewFakeRecorder(10)
+		publisher := &nodePublisher{
+			recorder:         recorder,
+			cache:            unreachable,
+			reader:           unreachable,
+			liveReadFallback: true,
+			snapshots:        snapshots,
+		}
+
+		got, err := publisher.read(context.TODO(), ccm.Name)
+		if !test.found {
+			require.Error(t, err, test.description)
+			continue
+		}
+		require.NoError(t, err, test.description)
+		require.Equal(t, ccm.Data, got.Data, test.description)
+		require.Len(t, recorder.Events, 1, "falling back to the snapshot should post an event")
+		require.Contains(t, <-recorder.Events, "Warning "+reasonSnapshotFallback)
+	}
+}
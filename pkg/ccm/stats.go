@@ -0,0 +1,104 @@
+package ccm
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+
+	"golang.org/x/sys/unix"
+)
+
+// volumeUsage reports the bytes and inodes used by the data dir of a volume, along with the capacity of the
+// filesystem holding it.
+func volumeUsage(dir string) ([]*csi.VolumeUsage, error) {
+	usedBytes, usedInodes, err := diskUsage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	statfs := &unix.Statfs_t{}
+	if err := unix.Statfs(dir, statfs); err != nil {
+		return nil, fmt.Errorf("failed to stat filesystem of data dir %q: %w", dir, err)
+	}
+	return []*csi.VolumeUsage{
+		{
+			Unit:      csi.VolumeUsage_BYTES,
+			Total:     int64(statfs.Blocks) * int64(statfs.Bsize),
+			Available: int64(statfs.Bavail) * int64(statfs.Bsize),
+			Used:      usedBytes,
+		},
+		{
+			Unit:      csi.VolumeUsage_INODES,
+			Total:     int64(statfs.Files),
+			Available: int64(statfs.Ffree),
+			Used:      usedInodes,
+		},
+	}, nil
+}
+
+// diskUsage counts the bytes of regular files and the inodes in a directory. Symlinks are counted as inodes but not
+// followed, so files are not counted twice.
+func diskUsage(dir string) (usedBytes, usedInodes int64, err error) {
+	err = filepath.WalkDir(dir, func(filePath string, dirEntry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		usedInodes++
+		if dirEntry.Type().IsRegular() {
+			info, err := dirEntry.Info()
+			if err != nil {
+				return err
+			}
+			usedBytes += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to walk data dir %q: %w", dir, err)
+	}
+	return usedBytes, usedInodes, nil
+}
+
+// volumeCondition reports the volume as abnormal when its target path is no longer mounted, when its data dir is
+// missing, or when the files in its data dir no longer match the checksums recorded in its metadata.
+func volumeCondition(meta *ClusterConfigMapMeta, mounted, populated bool) *csi.VolumeCondition {
+	var problems []string
+	if !mounted {
+		problems = append(problems, fmt.Sprintf("target path %q is not mounted", meta.TargetPath))
+	}
+	if !populated {
+		problems = append(problems, fmt.Sprintf("data dir of volume %q is missing", meta.VolumeID))
+	} else if drifted := verifyContents(meta.Directory); len(drifted) > 0 {
+		problems = append(problems, fmt.Sprintf("files %s do not match the checksums in the volume metadata", strings.Join(drifted, ", ")))
+	}
+	if len(problems) > 0 {
+		return &csi.VolumeCondition{Abnormal: true, Message: strings.Join(problems, "; ")}
+	}
+	return &csi.VolumeCondition{Abnormal: false, Message: "volume is healthy"}
+}
+
+// verifyContents returns the files of the directory which are missing or whose checksum does not match the metadata.
+func verifyContents(directory DirectoryMeta) []string {
+	var drifted []string
+	sha := sha512.New()
+	for _, content := range directory.Contents {
+		bytes, err := os.ReadFile(path.Join(directory.Path, content.Filename))
+		if err != nil {
+			drifted = append(drifted, fmt.Sprintf("%q", content.Filename))
+			continue
+		}
+		sha.Reset()
+		_, _ = sha.Write(bytes)
+		if hex.EncodeToString(sha.Sum(nil)) != content.SHA512 {
+			drifted = append(drifted, fmt.Sprintf("%q", content.Filename))
+		}
+	}
+	return drifted
+}
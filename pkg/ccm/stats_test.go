@@ -0,0 +1,153 @@
+package ccm
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/require"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"k8s.io/mount-utils"
+)
+
+func Test_volumeUsage(t *testing.T) {
+	dir := t.TempDir()
+	writer := &atomicWriter{targetDir: dir}
+	require.NoError(t, writer.Write(map[string]fileProjection{
+		"foo.txt":     {Data: []byte("foo"), Mode: 0644},
+		"conf/bar.sh": {Data: []byte("barbaz"), Mode: 0755},
+	}))
+
+	usage, err := volumeUsage(dir)
+	require.NoError(t, err)
+	require.Len(t, usage, 2)
+	require.Equal(t, csi.VolumeUsage_BYTES, usage[0].Unit)
+	require.Equal(t, int64(9), usage[0].Used, "only regular files should count towards used bytes")
+	require.Equal(t, csi.VolumeUsage_INODES, usage[1].Unit)
+	// target dir, ..data, foo.txt and conf links, timestamped dir, foo.txt, conf dir and conf/bar.sh
+	require.Equal(t, int64(8), usage[1].Used)
+}
+
+func Test_volumeCondition(t *testing.T) {
+	type testcase struct {
+		description string
+		mounted     bool
+		missing     bool
+		contents    string
+		abnormal    bool
+	}
+	tests := []testcase{
+		{
+			description: "a mounted volume with matching contents should be healthy",
+			mounted:     true,
+			contents:    "foo",
+			abnormal:    false,
+		},
+		{
+			description: "a volume which is no longer mounted should be abnormal",
+			mounted:     false,
+			contents:    "foo",
+			abnormal:    true,
+		},
+		{
+			description: "a volume whose contents drifted from the metadata should be abnormal",
+			mounted:     true,
+			contents:    "modified",
+			abnormal:    true,
+		},
+		{
+			description: "a volume whose data dir is missing should be abnormal",
+			mounted:     true,
+			missing:     true,
+			contents:    "foo",
+			abnormal:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(path.Join(dir, "foo.txt"), []byte(test.contents), 0644))
+			meta := &ClusterConfigMapMeta{
+				TargetPath: "/tmp/test-path",
+				Directory: DirectoryMeta{
+					Path: dir,
+					Contents: []ContentMeta{{
+						Filename: "foo.txt",
+						// sha512 of "foo"
+						SHA512: "f7fbba6e0636f890e56fbbf3283e524c6fa3204ae298382d624741d0dc6638326e282c41be5e4254d8820772c5518a2c5a8c0c7f7eda19594a7eb539453e1ed7",
+					}},
+				},
+			}
+			condition := volumeCondition(meta, test.mounted, !test.missing)
+			require.Equal(t, test.abnormal, condition.Abnormal, condition.Message)
+		})
+	}
+}
+
+func Test_NodeGetVolumeStats_MissingDataDir(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	targetPath := path.Join(t.TempDir(), "target")
+	meta := &ClusterConfigMapMeta{Name: "test-cluster-config-maps", VolumeID: "test-volume-id", TargetPath: targetPath}
+	require.NoError(t, volumes.WriteMetadata(meta))
+	dataDir := volumes.DataPath("test-volume-id")
+
+	fakeMounter := mount.NewFakeMounter(nil)
+	require.NoError(t, fakeMounter.Mount(dataDir, targetPath, "ext4", []string{"bind"}))
+	driver := newDriver("test", "", volumes, &mockVolumePublisher{})
+	driver.mounter = fakeMounter
+
+	res, err := driver.NodeGetVolumeStats(context.TODO(), &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume-id", VolumePath: targetPath})
+	require.NoError(t, err)
+	require.True(t, res.VolumeCondition.Abnormal, res.VolumeCondition.Message)
+	require.NoDirExists(t, dataDir, "the missing data dir should not be recreated")
+}
+
+func Test_NodeGetVolumeStats_Error(t *testing.T) {
+	type testcase struct {
+		description string
+		req         *csi.NodeGetVolumeStatsRequest
+		code        codes.Code
+	}
+	tests := []testcase{
+		{
+			description: "missing volume id",
+			req:         &csi.NodeGetVolumeStatsRequest{VolumePath: "/tmp/test-path"},
+			code:        codes.InvalidArgument,
+		},
+		{
+			description: "missing volume path",
+			req:         &csi.NodeGetVolumeStatsRequest{VolumeId: "test-volume-id"},
+			code:        codes.InvalidArgument,
+		},
+	}
+
+	driver := newDriver("test", "", newTestVolumeStore(t), &mockVolumePublisher{})
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := driver.NodeGetVolumeStats(context.TODO(), test.req)
+			require.Equal(t, test.code, status.Code(err))
+		})
+	}
+}
+
+func Test_NodeGetCapabilities(t *testing.T) {
+	driver := newDriver("test", "", newTestVolumeStore(t), &mockVolumePublisher{})
+	resp, err := driver.NodeGetCapabilities(context.TODO(), &csi.NodeGetCapabilitiesRequest{})
+	require.NoError(t, err)
+
+	capabilities := make([]csi.NodeServiceCapability_RPC_Type, 0, len(resp.Capabilities))
+	for _, capability := range resp.Capabilities {
+		capabilities = append(capabilities, capability.GetRpc().GetType())
+	}
+	require.ElementsMatch(t, []csi.NodeServiceCapability_RPC_Type{
+		csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP,
+		csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
+		csi.NodeServiceCapability_RPC_VOLUME_CONDITION,
+	}, capabilities)
+}
@@ -0,0 +1,197 @@
+package ccm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+
+	"k8s.io/mount-utils"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// statusReporter applies the volumes published on this node to the status of their cluster config maps. Every node
+// owns its own entry in the status node list through server side apply, so nodes never overwrite each other. Updates
+// are queued by cluster config map name, so a burst of publish requests collapses into a single status update.
+// Every status update changes the resource version of the cluster config map and is watched by every node, so the
+// status is only applied when the summary of this node changed since it was last applied.
+type statusReporter struct {
+	client   client.Client
+	mounter  mount.Interface
+	volumes  *volumeStore
+	nodeName string
+	queue    workqueue.RateLimitingInterface
+	// applied holds the last status applied for every cluster config map. It is only accessed by the status worker.
+	applied map[string]appliedStatus
+}
+
+// appliedStatus is the part of an applied status which is compared to decide whether the status changed.
+type appliedStatus struct {
+	uid                types.UID
+	observedGeneration int64
+	hash               string
+	volumes            int32
+	syncedHash         string
+}
+
+func newStatusReporter(c client.Client, mounter mount.Interface, volumes *volumeStore, nodeName string) *statusReporter {
+	return &statusReporter{
+		client:   c,
+		mounter:  mounter,
+		volumes:  volumes,
+		nodeName: nodeName,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "clusterconfigmap-status"),
+		applied:  make(map[string]appliedStatus),
+	}
+}
+
+// Enqueue schedules a status update for the named cluster config map.
+func (r *statusReporter) Enqueue(name string) {
+	r.queue.Add(name)
+}
+
+// Start runs the status update worker until the context is done.
+func (r *statusReporter) Start(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		r.queue.ShutDown()
+	}()
+	for r.processNextItem(ctx) {
+	}
+}
+
+func (r *statusReporter) processNextItem(ctx context.Context) bool {
+	item, shutdown := r.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.queue.Done(item)
+
+	name := item.(string)
+	if err := r.report(ctx, name); err != nil {
+		logger.Error(err, fmt.Sprintf("failed to update status of cluster config map %q, requeueing", name))
+		statusErr.WithLabelValues(name).Inc()
+		r.queue.AddRateLimited(item)
+		return true
+	}
+	r.queue.Forget(item)
+	return true
+}
+
+func (r *statusReporter) report(ctx context.Context, name string) error {
+	ccm := &v1alpha1.ClusterConfigMap{}
+	if err := r.client.Get(ctx, client.ObjectKey{Name: name}, ccm); err != nil {
+		if apierrors.IsNotFound(err) {
+			delete(r.applied, name)
+			return nil
+		}
+		return fmt.Errorf("failed to get cluster config map: %w", err)
+	}
+
+	metas, err := r.volumes.ListMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to list volume metadata: %w", err)
+	}
+	mounts, err := r.mounter.List()
+	if err != nil {
+		return fmt.Errorf("failed to list mounts: %w", err)
+	}
+	mounted := make(map[string]bool, len(mounts))
+	for _, mountPoint := range mounts {
+		mounted[mountPoint.Path] = true
+	}
+
+	apply := &v1alpha1.ClusterConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: v1alpha1.SchemeGroupVersion.String(),
+			Kind:       v1alpha1.ClusterConfigMapKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Status: v1alpha1.ClusterConfigMapStatus{
+			ObservedGeneration: ccm.Generation,
+			Hash:               contentHash(ccm),
+		},
+	}
+	nodeStatus := nodeStatusOf(r.nodeName, name, metas, mounted)
+	// omitting the node entry removes it, as this node is its only field manager
+	if nodeStatus.Volumes > 0 {
+		apply.Status.Nodes = []v1alpha1.NodeStatus{nodeStatus}
+	}
+	summary := appliedStatus{
+		uid:                ccm.UID,
+		observedGeneration: apply.Status.ObservedGeneration,
+		hash:               apply.Status.Hash,
+		volumes:            nodeStatus.Volumes,
+		syncedHash:         nodeStatus.SyncedHash,
+	}
+	if last, ok := r.applied[name]; ok && last == summary {
+		logger.V(3).Info(fmt.Sprintf("status of cluster config map %q is unchanged, skipping", name))
+		return nil
+	}
+
+	logger.V(3).Info(fmt.Sprintf("applying status of cluster config map %q: %+v", name, apply.Status))
+	err = r.client.Status().Patch(ctx, apply, client.Apply, client.FieldOwner(v1alpha1.Group+"/"+r.nodeName), client.ForceOwnership)
+	if err != nil {
+		return err
+	}
+	r.applied[name] = summary
+	return nil
+}
+
+// nodeStatusOf summarizes the mounted volumes of the named cluster config map.
+func nodeStatusOf(nodeName, name string, metas []*ClusterConfigMapMeta, mounted map[string]bool) v1alpha1.NodeStatus {
+	nodeStatus := v1alpha1.NodeStatus{
+		Name:           nodeName,
+		LastUpdateTime: metav1.Now(),
+	}
+	var oldest *ClusterConfigMapMeta
+	for _, meta := range metas {
+		if meta.Name != name || !mounted[meta.TargetPath] {
+			continue
+		}
+		nodeStatus.Volumes++
+		if oldest == nil || meta.Synced.Before(oldest.Synced) {
+			oldest = meta
+		}
+	}
+	if oldest != nil {
+		nodeStatus.SyncedHash = oldest.Hash
+	}
+	return nodeStatus
+}
+
+// contentHash returns a hash of the data and binary data of the cluster config map, independent of key order.
+func contentHash(ccm *v1alpha1.ClusterConfigMap) string {
+	sha := sha256.New()
+	write := func(kind string, keys []string, value func(key string) []byte) {
+		sort.Strings(keys)
+		for _, key := range keys {
+			_, _ = fmt.Fprintf(sha, "%s\x00%s\x00", kind, key)
+			_, _ = sha.Write(value(key))
+			_, _ = sha.Write([]byte{0})
+		}
+	}
+
+	dataKeys := make([]string, 0, len(ccm.Data))
+	for key := range ccm.Data {
+		dataKeys = append(dataKeys, key)
+	}
+	write("data", dataKeys, func(key string) []byte { return []byte(ccm.Data[key]) })
+
+	binaryKeys := make([]string, 0, len(ccm.BinaryData))
+	for key := range ccm.BinaryData {
+		binaryKeys = append(binaryKeys, key)
+	}
+	write("binaryData", binaryKeys, func(key string) []byte { return ccm.BinaryData[key] })
+
+	return hex.EncodeToString(sha.Sum(nil))[:16]
+}
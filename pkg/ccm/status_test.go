@@ -0,0 +1,96 @@
+package ccm
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/mount-utils"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_contentHash(t *testing.T) {
+	ccm := &v1alpha1.ClusterConfigMap{
+		Data:       map[string]string{"foo": "bar", "baz": "qux"},
+		BinaryData: map[string][]byte{"bin": {0xde, 0xad}},
+	}
+	hash := contentHash(ccm)
+	require.Equal(t, hash, contentHash(ccm.DeepCopy()), "hash should be stable")
+
+	moved := &v1alpha1.ClusterConfigMap{
+		Data:       map[string]string{"foo": "bar", "baz": "qux", "bin": "\xde\xad"},
+		BinaryData: map[string][]byte{},
+	}
+	require.NotEqual(t, hash, contentHash(moved), "moving a key between data and binaryData should change the hash")
+
+	ccm.Data["foo"] = "changed"
+	require.NotEqual(t, hash, contentHash(ccm), "changing a value should change the hash")
+}
+
+func Test_nodeStatusOf(t *testing.T) {
+	now := time.Now()
+	metas := []*ClusterConfigMapMeta{
+		{Name: "test-ccm", VolumeID: "a", TargetPath: "/a", Hash: "new", Synced: now},
+		{Name: "test-ccm", VolumeID: "b", TargetPath: "/b", Hash: "old", Synced: now.Add(-time.Minute)},
+		{Name: "test-ccm", VolumeID: "c", TargetPath: "/c", Hash: "unmounted", Synced: now.Add(-time.Hour)},
+		{Name: "other-ccm", VolumeID: "d", TargetPath: "/d", Hash: "other", Synced: now.Add(-time.Hour)},
+	}
+	mounted := map[string]bool{"/a": true, "/b": true, "/d": true}
+
+	nodeStatus := nodeStatusOf("test-node", "test-ccm", metas, mounted)
+	require.Equal(t, "test-node", nodeStatus.Name)
+	require.Equal(t, int32(2), nodeStatus.Volumes)
+	require.Equal(t, "old", nodeStatus.SyncedHash, "the least recently synced mounted volume should be reported")
+
+	nodeStatus = nodeStatusOf("test-node", "missing-ccm", metas, mounted)
+	require.Zero(t, nodeStatus.Volumes)
+	require.Empty(t, nodeStatus.SyncedHash)
+}
+
+func Test_statusReporter_report(t *testing.T) {
+	volumes := newTestVolumeStore(t)
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", UID: "test-uid", Generation: 1},
+		Data:       map[string]string{"key": "value"},
+	}
+	patches := 0
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(ccm).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourcePatch: func(context.Context, client.Client, string, client.Object, client.Patch, ...client.SubResourcePatchOption) error {
+			patches++
+			return nil
+		},
+	}).Build()
+
+	targetPath := path.Join(t.TempDir(), "target")
+	meta := &ClusterConfigMapMeta{Name: "test-ccm", VolumeID: "test-volume-id", TargetPath: targetPath, Hash: "synced"}
+	require.NoError(t, volumes.WriteMetadata(meta))
+	mounter := mount.NewFakeMounter(nil)
+	require.NoError(t, mounter.Mount(volumes.DataPath("test-volume-id"), targetPath, "ext4", []string{"bind"}))
+
+	reporter := newStatusReporter(c, mounter, volumes, "test-node")
+	require.NoError(t, reporter.report(context.TODO(), "test-ccm"))
+	require.Equal(t, 1, patches, "the status of the node should be applied")
+	require.NoError(t, reporter.report(context.TODO(), "test-ccm"))
+	require.Equal(t, 1, patches, "an unchanged status should not be applied again")
+
+	meta.Hash = "refreshed"
+	require.NoError(t, volumes.WriteMetadata(meta))
+	require.NoError(t, reporter.report(context.TODO(), "test-ccm"))
+	require.Equal(t, 2, patches, "a changed synced hash should be applied")
+
+	require.NoError(t, mounter.Unmount(targetPath))
+	require.NoError(t, reporter.report(context.TODO(), "test-ccm"))
+	require.Equal(t, 3, patches, "a changed volume count should be applied")
+}
@@ -0,0 +1,115 @@
+package ccm
+
+import (
+	"context"
+	"fmt"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// refreshFunc repopulates the volumes published for the named cluster config map at the given uid and generation.
+type refreshFunc func(ctx context.Context, name string, uid types.UID, generation int64) error
+
+// configMapWatcher watches cluster config maps and queues a refresh of the volumes published from them whenever
+// they change. Refreshes are keyed by the cluster config map name, so a burst of updates collapses into a single refresh.
+type configMapWatcher struct {
+	cache   cache.Cache
+	queue   workqueue.RateLimitingInterface
+	refresh refreshFunc
+}
+
+func newConfigMapWatcher(c cache.Cache, refresh refreshFunc) *configMapWatcher {
+	return &configMapWatcher{
+		cache:   c,
+		queue:   workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "clusterconfigmaps"),
+		refresh: refresh,
+	}
+}
+
+// Start registers the cluster config map informer, then runs the cache and the refresh worker until the context is done.
+func (w *configMapWatcher) Start(ctx context.Context) error {
+	informer, err := w.cache.GetInformer(ctx, &v1alpha1.ClusterConfigMap{})
+	if err != nil {
+		return fmt.Errorf("failed to get cluster config map informer: %w", err)
+	}
+	_, err = informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    w.enqueue,
+		UpdateFunc: w.update,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add cluster config map event handler: %w", err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		w.queue.ShutDown()
+	}()
+	go func() {
+		for w.processNextItem(ctx) {
+		}
+	}()
+
+	logger.Info("cluster config map watcher started")
+	return w.cache.Start(ctx)
+}
+
+func (w *configMapWatcher) enqueue(obj interface{}) {
+	ccm, ok := obj.(client.Object)
+	if !ok {
+		return
+	}
+	w.queue.Add(ccm.GetName())
+}
+
+// update queues a refresh of the volumes of an updated cluster config map. Updates which leave the generation
+// unchanged, like the status updates of every node, don't change the contents. The contents of cluster config maps
+// which were already immutable can not have changed either, so their volumes are not refreshed. A cluster config map
+// deleted and created again between two resyncs of the informer is always refreshed, its generation started over.
+func (w *configMapWatcher) update(oldObj, obj interface{}) {
+	old, oldOk := oldObj.(*v1alpha1.ClusterConfigMap)
+	updated, ok := obj.(*v1alpha1.ClusterConfigMap)
+	if !oldOk || !ok {
+		return
+	}
+	if old.UID == updated.UID && (old.Generation == updated.Generation || (old.Immutable != nil && *old.Immutable)) {
+		return
+	}
+	w.enqueue(updated)
+}
+
+func (w *configMapWatcher) processNextItem(ctx context.Context) bool {
+	item, shutdown := w.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer w.queue.Done(item)
+
+	name := item.(string)
+	ccm := &v1alpha1.ClusterConfigMap{}
+	if err := w.cache.Get(ctx, client.ObjectKey{Name: name}, ccm); err != nil {
+		if apierrors.IsNotFound(err) {
+			// deleted cluster config maps keep serving their last contents, the same as native config map volumes
+			w.queue.Forget(item)
+			return true
+		}
+		logger.Error(err, fmt.Sprintf("failed to get cluster config map %q from cache, requeueing", name))
+		w.queue.AddRateLimited(item)
+		return true
+	}
+
+	if err := w.refresh(ctx, ccm.Name, ccm.UID, ccm.Generation); err != nil {
+		logger.Error(err, fmt.Sprintf("failed to refresh volumes for cluster config map %q, requeueing", name))
+		w.queue.AddRateLimited(item)
+		return true
+	}
+	w.queue.Forget(item)
+	return true
+}
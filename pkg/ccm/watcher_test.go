@@ -0,0 +1,185 @@
+package ccm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func Test_configMapWatcher_update(t *testing.T) {
+	immutable := true
+	mutable := false
+
+	type testcase struct {
+		description string
+		old         *bool
+		updated     *bool
+		recreated   bool
+		queued      bool
+	}
+	tests := []testcase{
+		{
+			description: "updates of mutable cluster config maps should be refreshed",
+			old:         nil,
+			queued:      true,
+		},
+		{
+			description: "updates of cluster config maps explicitly set as mutable should be refreshed",
+			old:         &mutable,
+			updated:     &mutable,
+			queued:      true,
+		},
+		{
+			description: "updates making a cluster config map immutable should be refreshed",
+			old:         nil,
+			updated:     &immutable,
+			queued:      true,
+		},
+		{
+			description: "updates of immutable cluster config maps should not be refreshed",
+			old:         &immutable,
+			updated:     &immutable,
+			queued:      false,
+		},
+		{
+			description: "immutable cluster config maps created again at the same generation should be refreshed",
+			old:         &immutable,
+			updated:     &immutable,
+			recreated:   true,
+			queued:      true,
+		},
+	}
+
+	for _, test := range tests {
+		w := newConfigMapWatcher(nil, nil)
+		old := &v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", UID: "test-uid", ResourceVersion: "1", Generation: 1}, Immutable: test.old}
+		updated := &v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", UID: "test-uid", ResourceVersion: "2", Generation: 2}, Immutable: test.updated}
+		if test.recreated {
+			updated.UID = "recreated-uid"
+			updated.Generation = 1
+		}
+		w.update(old, updated)
+		if test.queued {
+			require.Equal(t, 1, w.queue.Len(), test.description)
+		} else {
+			require.Equal(t, 0, w.queue.Len(), test.description)
+		}
+		w.queue.ShutDown()
+	}
+}
+
+func Test_configMapWatcher_update_StatusOnly(t *testing.T) {
+	w := newConfigMapWatcher(nil, nil)
+	defer w.queue.ShutDown()
+	old := &v1alpha1.ClusterConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", ResourceVersion: "1", Generation: 1}}
+	updated := old.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Status.Nodes = []v1alpha1.NodeStatus{{Name: "test-node", Volumes: 1}}
+	w.update(old, updated)
+	require.Equal(t, 0, w.queue.Len(), "status updates should not be refreshed")
+}
+
+// fakeCache serves reads of the cache from a client, the watcher only reads cluster config maps from its cache.
+type fakeCache struct {
+	cache.Cache
+	reader client.Reader
+}
+
+func (c *fakeCache) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	return c.reader.Get(ctx, key, obj, opts...)
+}
+
+func Test_configMapWatcher_processNextItem(t *testing.T) {
+	type testcase struct {
+		description string
+		objects     []client.Object
+		getErr      error
+		refreshErr  error
+		refreshed   bool
+		requeued    bool
+	}
+	tests := []testcase{
+		{
+			description: "cluster config maps should be refreshed at their generation",
+			objects: []client.Object{&v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", UID: "test-uid", Generation: 3},
+			}},
+			refreshed: true,
+			requeued:  false,
+		},
+		{
+			description: "failed refreshes should be requeued",
+			objects: []client.Object{&v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm", UID: "test-uid", Generation: 3},
+			}},
+			refreshErr: errVolumeBusy,
+			refreshed:  true,
+			requeued:   true,
+		},
+		{
+			description: "deleted cluster config maps should not be refreshed or requeued",
+			refreshed:   false,
+			requeued:    false,
+		},
+		{
+			description: "failed cache reads should be requeued",
+			getErr:      errors.New("cache is not synced"),
+			refreshed:   false,
+			requeued:    true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			reader := fake.NewClientBuilder().WithScheme(scheme).WithObjects(test.objects...).WithInterceptorFuncs(interceptor.Funcs{
+				Get: func(ctx context.Context, c client.WithWatch, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+					if test.getErr != nil {
+						return test.getErr
+					}
+					return c.Get(ctx, key, obj, opts...)
+				},
+			}).Build()
+
+			refreshed := false
+			w := newConfigMapWatcher(&fakeCache{reader: reader}, func(_ context.Context, name string, uid types.UID, generation int64) error {
+				refreshed = true
+				require.Equal(t, "test-ccm", name)
+				require.Equal(t, types.UID("test-uid"), uid)
+				require.Equal(t, int64(3), generation)
+				return test.refreshErr
+			})
+			defer w.queue.ShutDown()
+
+			w.queue.Add("test-ccm")
+			require.True(t, w.processNextItem(context.TODO()))
+			require.Equal(t, test.refreshed, refreshed)
+			if test.requeued {
+				require.Equal(t, 1, w.queue.NumRequeues("test-ccm"), "the item should be requeued with backoff")
+			} else {
+				require.Zero(t, w.queue.NumRequeues("test-ccm"))
+				require.Zero(t, w.queue.Len())
+			}
+		})
+	}
+
+	t.Run("processing should stop once the queue is shut down", func(t *testing.T) {
+		w := newConfigMapWatcher(nil, nil)
+		w.queue.ShutDown()
+		require.False(t, w.processNextItem(context.TODO()))
+	})
+}
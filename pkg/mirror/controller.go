@@ -0,0 +1,267 @@
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/record"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// ManagedByLabel marks the config maps mirrored from cluster config maps. Only config maps with the label are
+	// cached, and config maps without it are never overwritten.
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedBy is the value of the ManagedByLabel on mirrored config maps.
+	ManagedBy = "ccm-controller"
+
+	// ownerIndex indexes mirrored config maps by the name of the cluster config map controlling them.
+	ownerIndex = ".metadata.controller"
+
+	reasonConflict = "MirrorConflict"
+	reasonInvalid  = "InvalidClusterConfigMap"
+)
+
+// errConflict is returned when a config map of the same name exists, but is not mirrored from the cluster config map.
+var errConflict = errors.New("config map is not managed by the cluster config map")
+
+// CacheOptions restricts the config maps cached by the manager to the mirrored config maps.
+func CacheOptions() cache.Options {
+	return cache.Options{
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.ConfigMap{}: {Label: labels.SelectorFromSet(labels.Set{ManagedByLabel: ManagedBy})},
+		},
+	}
+}
+
+// Reconciler mirrors cluster config maps into config maps of the same name in the namespaces selected by their
+// mirror, so they can be used as environment variable sources. Mirrored config maps are controlled by their cluster
+// config map: changes to them are reverted, they are deleted when their namespace is no longer selected, and they are
+// garbage collected by kubernetes when the cluster config map is deleted.
+type Reconciler struct {
+	client.Client
+	Recorder record.EventRecorder
+}
+
+// SetupWithManager indexes mirrored config maps by their cluster config map, and registers the controller with the
+// manager. Cluster config maps are reconciled when their generation changes, so the status updates of every node are
+// ignored, when a config map mirrored from them changes, and for every mirrored cluster config map when a namespace
+// changes.
+func (r *Reconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &corev1.ConfigMap{}, ownerIndex, indexOwner); err != nil {
+		return fmt.Errorf("failed to index config maps by owner: %w", err)
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha1.ClusterConfigMap{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&corev1.Namespace{}, handler.EnqueueRequestsFromMapFunc(r.mirroredClusterConfigMaps), builder.OnlyMetadata).
+		Complete(r)
+}
+
+// Reconcile creates or updates the config maps mirrored from the cluster config map in every selected namespace, and
+// deletes them from namespaces which are no longer selected.
+func (r *Reconciler) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	logger := log.FromContext(ctx)
+
+	ccm := &v1alpha1.ClusterConfigMap{}
+	if err := r.Get(ctx, req.NamespacedName, ccm); err != nil {
+		// mirrored config maps of deleted cluster config maps are garbage collected through their owner reference
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	if errs := ccm.Validate(); len(errs) > 0 {
+		// invalid cluster config maps are not retried, they are reconciled again once they are fixed
+		r.Recorder.Eventf(ccm, corev1.EventTypeWarning, reasonInvalid, "Not mirroring the cluster config map: %s", errs.ToAggregate())
+		return reconcile.Result{}, nil
+	}
+
+	namespaces, err := r.selectNamespaces(ctx, ccm)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	mirrored := &corev1.ConfigMapList{}
+	if err := r.List(ctx, mirrored, client.MatchingFields{ownerIndex: ccm.Name}); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to list mirrored config maps: %w", err)
+	}
+	var errs []error
+	for i := range mirrored.Items {
+		cm := &mirrored.Items[i]
+		if namespaces.Has(cm.Namespace) {
+			continue
+		}
+		logger.Info("deleting mirrored config map", "namespace", cm.Namespace)
+		if err := r.Delete(ctx, cm); client.IgnoreNotFound(err) != nil {
+			errs = append(errs, fmt.Errorf("failed to delete config map %s/%s: %w", cm.Namespace, cm.Name, err))
+		}
+	}
+
+	for _, namespace := range sets.List(namespaces) {
+		err := r.mirror(ctx, ccm, namespace)
+		if errors.Is(err, errConflict) {
+			// conflicting config maps are not retried, they are reconciled again once they are deleted
+			r.Recorder.Eventf(ccm, corev1.EventTypeWarning, reasonConflict, "Not mirroring into namespace %q: %s", namespace, err)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return reconcile.Result{}, errors.Join(errs...)
+}
+
+// mirror creates or updates the config map mirrored from the cluster config map in the namespace. Config maps are
+// only updated when their contents, labels or owner drifted from the cluster config map.
+func (r *Reconciler) mirror(ctx context.Context, ccm *v1alpha1.ClusterConfigMap, namespace string) error {
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: ccm.Name}}
+	if err := r.replaceImmutable(ctx, ccm, cm); err != nil {
+		return err
+	}
+	result, err := controllerutil.CreateOrUpdate(ctx, r.Client, cm, func() error {
+		if cm.ResourceVersion != "" && !isMirroredFrom(cm, ccm) {
+			return fmt.Errorf("%w: config map %s/%s already exists", errConflict, namespace, ccm.Name)
+		}
+		if cm.Labels == nil {
+			cm.Labels = map[string]string{}
+		}
+		cm.Labels[ManagedByLabel] = ManagedBy
+		cm.Data = ccm.Data
+		cm.BinaryData = ccm.BinaryData
+		cm.Immutable = ccm.Immutable
+		return controllerutil.SetControllerReference(ccm, cm, r.Scheme())
+	})
+	if apierrors.IsAlreadyExists(err) {
+		// config maps which are not mirrored are not cached, so they are only found when creating them
+		return fmt.Errorf("%w: config map %s/%s already exists", errConflict, namespace, ccm.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to mirror into namespace %q: %w", namespace, err)
+	}
+	if result != controllerutil.OperationResultNone {
+		log.FromContext(ctx).Info("mirrored cluster config map", "namespace", namespace, "operation", result)
+	}
+	return nil
+}
+
+// replaceImmutable deletes the immutable config map mirrored from the cluster config map when its contents differ,
+// so it is created again. The contents of immutable config maps can not be updated, they only differ when the cluster
+// config map was deleted and created again with other contents before its mirrors were garbage collected.
+func (r *Reconciler) replaceImmutable(ctx context.Context, ccm *v1alpha1.ClusterConfigMap, cm *corev1.ConfigMap) error {
+	existing := &corev1.ConfigMap{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(cm), existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if existing.Immutable == nil || !*existing.Immutable || !isMirroredFrom(existing, ccm) {
+		return nil
+	}
+	if equality.Semantic.DeepEqual(existing.Data, ccm.Data) && equality.Semantic.DeepEqual(existing.BinaryData, ccm.BinaryData) {
+		return nil
+	}
+	log.FromContext(ctx).Info("replacing immutable mirrored config map", "namespace", cm.Namespace)
+	if err := r.Delete(ctx, existing); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("failed to delete immutable config map %s/%s: %w", cm.Namespace, cm.Name, err)
+	}
+	return nil
+}
+
+// selectNamespaces returns the namespaces selected by the mirror of the cluster config map, which are allowed to
+// mount it. Terminating namespaces are never selected, config maps can not be created in them.
+func (r *Reconciler) selectNamespaces(ctx context.Context, ccm *v1alpha1.ClusterConfigMap) (sets.Set[string], error) {
+	selected := sets.New[string]()
+	if ccm.Mirror == nil || !ccm.DeletionTimestamp.IsZero() {
+		return selected, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&ccm.Mirror.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror namespace selector: %w", err)
+	}
+
+	namespaces := &metav1.PartialObjectMetadataList{}
+	namespaces.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("NamespaceList"))
+	if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %w", err)
+	}
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if ns.DeletionTimestamp.IsZero() && allowed(ccm, ns) {
+			selected.Insert(ns.Name)
+		}
+	}
+	return selected, nil
+}
+
+// mirroredClusterConfigMaps maps a namespace to every cluster config map with a mirror, as any of them may select it.
+func (r *Reconciler) mirroredClusterConfigMaps(ctx context.Context, _ client.Object) []reconcile.Request {
+	ccms := &v1alpha1.ClusterConfigMapList{}
+	if err := r.List(ctx, ccms); err != nil {
+		log.FromContext(ctx).Error(err, "failed to list cluster config maps")
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, ccm := range ccms.Items {
+		if ccm.Mirror != nil {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKey{Name: ccm.Name}})
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].Name < requests[j].Name
+	})
+	return requests
+}
+
+// allowed checks the namespace is allowed to mount the cluster config map, the same as the csi node plugin does
+// for pods.
+func allowed(ccm *v1alpha1.ClusterConfigMap, ns *metav1.PartialObjectMetadata) bool {
+	if len(ccm.AllowedNamespaces) == 0 && ccm.NamespaceSelector == nil {
+		return true
+	}
+	if slices.Contains(ccm.AllowedNamespaces, ns.Name) {
+		return true
+	}
+	if ccm.NamespaceSelector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(ccm.NamespaceSelector)
+	return err == nil && selector.Matches(labels.Set(ns.Labels))
+}
+
+// isMirroredFrom checks the config map is labeled as mirrored and controlled by the named cluster config map. The
+// owner is matched by name rather than uid, so cluster config maps which are deleted and recreated adopt their mirrors.
+func isMirroredFrom(cm *corev1.ConfigMap, ccm *v1alpha1.ClusterConfigMap) bool {
+	if cm.Labels[ManagedByLabel] != ManagedBy {
+		return false
+	}
+	owner := metav1.GetControllerOf(cm)
+	return owner != nil && owner.Kind == v1alpha1.ClusterConfigMapKind && owner.Name == ccm.Name
+}
+
+func indexOwner(obj client.Object) []string {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != v1alpha1.ClusterConfigMapKind {
+		return nil
+	}
+	if gv, err := schema.ParseGroupVersion(owner.APIVersion); err != nil || gv.Group != v1alpha1.Group {
+		return nil
+	}
+	return []string{owner.Name}
+}
@@ -0,0 +1,232 @@
+package mirror
+
+import (
+	"context"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func newNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newReconciler(t *testing.T, objs ...client.Object) (*Reconciler, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(scheme))
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	c := fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithIndex(&corev1.ConfigMap{}, ownerIndex, indexOwner).
+		Build()
+	recorder := record.NewFakeRecorder(10)
+	return &Reconciler{Client: c, Recorder: recorder}, recorder
+}
+
+func reconcileCCM(t *testing.T, r *Reconciler, name string) {
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: client.ObjectKey{Name: name}})
+	require.NoError(t, err)
+}
+
+// mirroredNamespaces returns the namespaces holding a config map mirrored from the named cluster config map.
+func mirroredNamespaces(t *testing.T, r *Reconciler, name string) []string {
+	cms := &corev1.ConfigMapList{}
+	require.NoError(t, r.List(context.TODO(), cms, client.MatchingFields{ownerIndex: name}))
+	var namespaces []string
+	for _, cm := range cms.Items {
+		namespaces = append(namespaces, cm.Namespace)
+	}
+	return namespaces
+}
+
+func Test_Reconciler_Reconcile(t *testing.T) {
+	type testcase struct {
+		description string
+		ccm         *v1alpha1.ClusterConfigMap
+		expected    []string
+	}
+	tests := []testcase{
+		{
+			description: "cluster config maps without a mirror should not be mirrored",
+			ccm: &v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+				Data:       map[string]string{"key": "value"},
+			},
+		},
+		{
+			description: "an empty mirror selector should mirror into every namespace",
+			ccm: &v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+				Data:       map[string]string{"key": "value"},
+				Mirror:     &v1alpha1.Mirror{},
+			},
+			expected: []string{"default", "team-a", "team-b"},
+		},
+		{
+			description: "the mirror selector should select namespaces by label",
+			ccm: &v1alpha1.ClusterConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+				Data:       map[string]string{"key": "value"},
+				Mirror: &v1alpha1.Mirror{
+					NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "true"}},
+				},
+			},
+			expected: []string{"team-a", "team-b"},
+		},
+		{
+			description: "only namespaces allowed to mount the cluster config map should be mirrored into",
+			ccm: &v1alpha1.ClusterConfigMap{
+				ObjectMeta:        metav1.ObjectMeta{Name: "test-ccm"},
+				Data:              map[string]string{"key": "value"},
+				AllowedNamespaces: []string{"team-b"},
+				Mirror: &v1alpha1.Mirror{
+					NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "true"}},
+				},
+			},
+			expected: []string{"team-b"},
+		},
+	}
+
+	for _, test := range tests {
+		r, _ := newReconciler(t, test.ccm,
+			newNamespace("default", nil),
+			newNamespace("team-a", map[string]string{"team": "true"}),
+			newNamespace("team-b", map[string]string{"team": "true"}),
+		)
+		reconcileCCM(t, r, test.ccm.Name)
+		require.ElementsMatch(t, test.expected, mirroredNamespaces(t, r, test.ccm.Name), test.description)
+
+		for _, namespace := range test.expected {
+			cm := &corev1.ConfigMap{}
+			require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: namespace, Name: test.ccm.Name}, cm))
+			require.Equal(t, test.ccm.Data, cm.Data, test.description)
+			require.Equal(t, ManagedBy, cm.Labels[ManagedByLabel], test.description)
+		}
+	}
+}
+
+func Test_Reconciler_Reconcile_Drift(t *testing.T) {
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+		Data:       map[string]string{"key": "value"},
+		BinaryData: map[string][]byte{"binary": {0xff}},
+		Mirror:     &v1alpha1.Mirror{},
+	}
+	r, _ := newReconciler(t, ccm, newNamespace("default", nil))
+	reconcileCCM(t, r, ccm.Name)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: ccm.Name}, cm))
+	cm.Data = map[string]string{"key": "changed", "extra": "value"}
+	cm.BinaryData = nil
+	require.NoError(t, r.Update(context.TODO(), cm))
+
+	reconcileCCM(t, r, ccm.Name)
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: ccm.Name}, cm))
+	require.Equal(t, ccm.Data, cm.Data, "changes to mirrored config maps should be reverted")
+	require.Equal(t, ccm.BinaryData, cm.BinaryData, "changes to mirrored config maps should be reverted")
+}
+
+func Test_Reconciler_Reconcile_Unselect(t *testing.T) {
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+		Data:       map[string]string{"key": "value"},
+		Mirror: &v1alpha1.Mirror{
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"team": "true"}},
+		},
+	}
+	r, _ := newReconciler(t, ccm,
+		newNamespace("team-a", map[string]string{"team": "true"}),
+		newNamespace("team-b", map[string]string{"team": "true"}),
+	)
+	reconcileCCM(t, r, ccm.Name)
+	require.ElementsMatch(t, []string{"team-a", "team-b"}, mirroredNamespaces(t, r, ccm.Name))
+
+	ns := &corev1.Namespace{}
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Name: "team-a"}, ns))
+	ns.Labels = nil
+	require.NoError(t, r.Update(context.TODO(), ns))
+	reconcileCCM(t, r, ccm.Name)
+	require.ElementsMatch(t, []string{"team-b"}, mirroredNamespaces(t, r, ccm.Name), "unselected namespaces should be cleaned up")
+
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Name: ccm.Name}, ccm))
+	ccm.Mirror = nil
+	require.NoError(t, r.Update(context.TODO(), ccm))
+	reconcileCCM(t, r, ccm.Name)
+	require.Empty(t, mirroredNamespaces(t, r, ccm.Name), "removing the mirror should clean up every namespace")
+}
+
+func Test_Reconciler_Reconcile_Conflict(t *testing.T) {
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+		Data:       map[string]string{"key": "value"},
+		Mirror:     &v1alpha1.Mirror{},
+	}
+	existing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team-a", Name: "test-ccm"},
+		Data:       map[string]string{"key": "owned by team-a"},
+	}
+	r, recorder := newReconciler(t, ccm, existing, newNamespace("team-a", nil), newNamespace("team-b", nil))
+	reconcileCCM(t, r, ccm.Name)
+
+	require.ElementsMatch(t, []string{"team-b"}, mirroredNamespaces(t, r, ccm.Name))
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: "team-a", Name: ccm.Name}, cm))
+	require.Equal(t, existing.Data, cm.Data, "config maps which are not mirrored should not be overwritten")
+	require.Len(t, recorder.Events, 1)
+	require.Contains(t, <-recorder.Events, "Warning "+reasonConflict)
+}
+
+func Test_Reconciler_Reconcile_NotFound(t *testing.T) {
+	r, _ := newReconciler(t)
+	_, err := r.Reconcile(context.TODO(), reconcile.Request{NamespacedName: client.ObjectKey{Name: "test-ccm"}})
+	require.NoError(t, err)
+
+	cm := &corev1.ConfigMap{}
+	err = r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: "test-ccm"}, cm)
+	require.True(t, apierrors.IsNotFound(err))
+}
+
+func Test_Reconciler_Reconcile_Immutable(t *testing.T) {
+	immutable := true
+	ccm := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+		Data:       map[string]string{"key": "value"},
+		Immutable:  &immutable,
+		Mirror:     &v1alpha1.Mirror{},
+	}
+	r, _ := newReconciler(t, ccm, newNamespace("default", nil))
+	reconcileCCM(t, r, ccm.Name)
+
+	cm := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: ccm.Name}, cm))
+	require.Equal(t, &immutable, cm.Immutable, "immutable cluster config maps should be mirrored as immutable")
+
+	// recreate the cluster config map with other contents, before its mirror is garbage collected
+	require.NoError(t, r.Delete(context.TODO(), ccm))
+	recreated := &v1alpha1.ClusterConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-ccm"},
+		Data:       map[string]string{"key": "other value"},
+		Immutable:  &immutable,
+		Mirror:     &v1alpha1.Mirror{},
+	}
+	require.NoError(t, r.Create(context.TODO(), recreated))
+	reconcileCCM(t, r, ccm.Name)
+
+	require.NoError(t, r.Get(context.TODO(), client.ObjectKey{Namespace: "default", Name: ccm.Name}, cm))
+	require.Equal(t, recreated.Data, cm.Data, "immutable mirrors with other contents should be replaced")
+}
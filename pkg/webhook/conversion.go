@@ -0,0 +1,16 @@
+package webhook
+
+import (
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// SetupConversionWithManager registers the conversion webhook between the versions of cluster config maps with the
+// webhook server of the manager, served at /convert. Every version is converted through the v1beta1 hub, so the
+// scheme of the manager must include every served version.
+func SetupConversionWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1beta1.ClusterConfigMap{}).
+		Complete()
+}
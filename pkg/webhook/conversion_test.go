@@ -0,0 +1,79 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1beta1"
+
+	"github.com/stretchr/testify/require"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
+)
+
+// convert sends the object to the conversion webhook, and returns the object converted to the api version.
+func convert(t *testing.T, obj runtime.Object, apiVersion string) []byte {
+	scheme := runtime.NewScheme()
+	require.NoError(t, v1alpha1.AddToScheme(scheme))
+	require.NoError(t, v1beta1.AddToScheme(scheme))
+
+	raw, err := json.Marshal(obj)
+	require.NoError(t, err)
+	review := &apiextensionsv1.ConversionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: apiextensionsv1.SchemeGroupVersion.String(), Kind: "ConversionReview"},
+		Request: &apiextensionsv1.ConversionRequest{
+			UID:               types.UID("test-uid"),
+			DesiredAPIVersion: apiVersion,
+			Objects:           []runtime.RawExtension{{Raw: raw}},
+		},
+	}
+	body, err := json.Marshal(review)
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/convert", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	conversion.NewWebhookHandler(scheme).ServeHTTP(recorder, req)
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	response := &apiextensionsv1.ConversionReview{}
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), response))
+	require.Equal(t, metav1.StatusSuccess, response.Response.Result.Status, response.Response.Result.Message)
+	require.Len(t, response.Response.ConvertedObjects, 1)
+	return response.Response.ConvertedObjects[0].Raw
+}
+
+func Test_ConversionWebhook(t *testing.T) {
+	immutable := true
+	ccm := &v1alpha1.ClusterConfigMap{
+		TypeMeta:          metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: v1alpha1.ClusterConfigMapKind},
+		ObjectMeta:        metav1.ObjectMeta{Name: "test-ccm"},
+		Data:              map[string]string{"config.yaml": "key: value"},
+		BinaryData:        map[string][]byte{"config.bin": {0xff}},
+		FileAttributes:    map[string]v1alpha1.FileAttributes{"config.yaml": {Mode: "0600"}},
+		Immutable:         &immutable,
+		AllowedNamespaces: []string{"team-a"},
+		Mirror:            &v1alpha1.Mirror{},
+		Status:            v1alpha1.ClusterConfigMapStatus{Hash: "hash"},
+	}
+
+	hub := &v1beta1.ClusterConfigMap{}
+	require.NoError(t, json.Unmarshal(convert(t, ccm, v1beta1.SchemeGroupVersion.String()), hub))
+	require.Equal(t, v1beta1.SchemeGroupVersion.String(), hub.APIVersion)
+	require.Equal(t, ccm.Data, hub.Spec.Data)
+	require.Equal(t, ccm.AllowedNamespaces, hub.Spec.Access.AllowedNamespaces)
+	require.Equal(t, "0600", hub.Spec.Projection.FileAttributes["config.yaml"].Mode)
+
+	got := &v1alpha1.ClusterConfigMap{}
+	require.NoError(t, json.Unmarshal(convert(t, hub, v1alpha1.SchemeGroupVersion.String()), got))
+	require.Equal(t, ccm, got, "converting through the webhook should round trip")
+}
@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DefaultMaxSize is the default cap on the size of the data and binary data of a cluster config map, the same as the
+// size limit of native config maps.
+const DefaultMaxSize = 1024 * 1024
+
+// Validator rejects cluster config maps which would fail to publish on the nodes, so they are reported by the
+// apiserver instead of at mount time: invalid or overlapping keys, file attributes of missing keys, invalid
+// namespace policies or mirrors, and contents larger than MaxSize.
+type Validator struct {
+	// MaxSize is the maximum total size in bytes of the data and binary data values, 0 disables the cap.
+	MaxSize int
+}
+
+var _ admission.CustomValidator = &Validator{}
+
+// SetupWithManager registers the validating webhook of cluster config maps with the webhook server of the manager.
+func (v *Validator) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.ClusterConfigMap{}).
+		WithValidator(v).
+		Complete()
+}
+
+// ValidateCreate validates a new cluster config map.
+func (v *Validator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(obj)
+}
+
+// ValidateUpdate validates the updated cluster config map. Existing cluster config maps which are no longer valid,
+// for example after lowering the size cap, must be fixed by the update.
+func (v *Validator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	return nil, v.validate(newObj)
+}
+
+// ValidateDelete allows every deletion.
+func (v *Validator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (v *Validator) validate(obj runtime.Object) error {
+	ccm, ok := obj.(*v1alpha1.ClusterConfigMap)
+	if !ok {
+		return fmt.Errorf("expected a cluster config map, got %T", obj)
+	}
+	errs := ccm.Validate()
+	if v.MaxSize > 0 {
+		if size := dataSize(ccm); size > v.MaxSize {
+			errs = append(errs, field.TooLong(field.NewPath("data"), "", v.MaxSize))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: v1alpha1.Group, Kind: v1alpha1.ClusterConfigMapKind}, ccm.Name, errs)
+}
+
+// dataSize returns the total size of the data and binary data values, counted the same as the apiserver counts
+// the size of native config maps.
+func dataSize(ccm *v1alpha1.ClusterConfigMap) int {
+	var size int
+	for _, value := range ccm.Data {
+		size += len(value)
+	}
+	for _, value := range ccm.BinaryData {
+		size += len(value)
+	}
+	return size
+}
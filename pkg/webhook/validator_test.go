@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"indeed.com/compute-platform/cluster-config-map/apis/clusterconfigmap/v1alpha1"
+
+	"github.com/stretchr/testify/require"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_Validator(t *testing.T) {
+	type testcase struct {
+		description string
+		ccm         *v1alpha1.ClusterConfigMap
+		maxSize     int
+		errs        []string
+	}
+	tests := []testcase{
+		{
+			description: "valid cluster config maps should be admitted",
+			ccm: &v1alpha1.ClusterConfigMap{
+				Data:       map[string]string{"config.yaml": "key: value"},
+				BinaryData: map[string][]byte{"config.bin": {0xff}},
+				FileAttributes: map[string]v1alpha1.FileAttributes{
+					"config.yaml": {Mode: "0600"},
+				},
+				AllowedNamespaces: []string{"team-a"},
+				Mirror:            &v1alpha1.Mirror{},
+			},
+			maxSize: DefaultMaxSize,
+		},
+		{
+			description: "keys which are not valid file names should be rejected",
+			ccm: &v1alpha1.ClusterConfigMap{
+				Data:       map[string]string{"../etc/passwd": "root"},
+				BinaryData: map[string][]byte{"..data": {0xff}},
+			},
+			maxSize: DefaultMaxSize,
+			errs:    []string{"data[../etc/passwd]", "binaryData[..data]"},
+		},
+		{
+			description: "keys in both data and binary data should be rejected",
+			ccm: &v1alpha1.ClusterConfigMap{
+				Data:       map[string]string{"config": "value"},
+				BinaryData: map[string][]byte{"config": {0xff}},
+			},
+			maxSize: DefaultMaxSize,
+			errs:    []string{"binaryData[config]: Duplicate value"},
+		},
+		{
+			description: "contents larger than the size cap should be rejected",
+			ccm: &v1alpha1.ClusterConfigMap{
+				Data:       map[string]string{"config": strings.Repeat("a", 8)},
+				BinaryData: map[string][]byte{"binary": make([]byte, 8)},
+			},
+			maxSize: 15,
+			errs:    []string{"data: Too long: must have at most 15 bytes"},
+		},
+		{
+			description: "a size cap of 0 should not cap the contents",
+			ccm: &v1alpha1.ClusterConfigMap{
+				Data: map[string]string{"config": strings.Repeat("a", 2*DefaultMaxSize)},
+			},
+		},
+		{
+			description: "invalid namespace policies and mirrors should be rejected",
+			ccm: &v1alpha1.ClusterConfigMap{
+				AllowedNamespaces: []string{"Team_A"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a b"}},
+				Mirror: &v1alpha1.Mirror{
+					NamespaceSelector: metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "team", Operator: "Contains"},
+					}},
+				},
+			},
+			maxSize: DefaultMaxSize,
+			errs:    []string{"allowedNamespaces[0]", "namespaceSelector.matchLabels", "mirror.namespaceSelector.matchExpressions[0].operator"},
+		},
+	}
+
+	for _, test := range tests {
+		test.ccm.Name = "test-ccm"
+		validator := &Validator{MaxSize: test.maxSize}
+
+		_, createErr := validator.ValidateCreate(context.TODO(), test.ccm)
+		_, updateErr := validator.ValidateUpdate(context.TODO(), &v1alpha1.ClusterConfigMap{}, test.ccm)
+		for _, err := range []error{createErr, updateErr} {
+			if len(test.errs) == 0 {
+				require.NoError(t, err, test.description)
+				continue
+			}
+			require.True(t, apierrors.IsInvalid(err), test.description)
+			for _, msg := range test.errs {
+				require.Contains(t, err.Error(), msg, test.description)
+			}
+		}
+	}
+}
+
+func Test_Validator_ValidateDelete(t *testing.T) {
+	invalid := &v1alpha1.ClusterConfigMap{Data: map[string]string{"../etc/passwd": "root"}}
+	_, err := (&Validator{MaxSize: DefaultMaxSize}).ValidateDelete(context.TODO(), invalid)
+	require.NoError(t, err, "invalid cluster config maps should be deletable")
+}